@@ -0,0 +1,32 @@
+package planningsvc
+
+import (
+	"planning-poker/domain/planning"
+)
+
+// Subscribe registers a subscriber for the events of all plannings
+func (svc *PlanningService) Subscribe(subscriber planning.Subscriber) {
+	svc.subscribersMu.Lock()
+	defer svc.subscribersMu.Unlock()
+	svc.subscribers = append(svc.subscribers, subscriber)
+}
+
+func (svc *PlanningService) publish(event planning.Event) {
+	svc.subscribersMu.RLock()
+	subscribers := svc.subscribers
+	svc.subscribersMu.RUnlock()
+	for _, subscriber := range subscribers {
+		subscriber.Handle(event)
+	}
+}
+
+// shared returns the planning as every player may see it, with the stats of a revealed
+// round and the remaining time of the timer
+func (svc *PlanningService) shared(p planning.Planning) planning.Planning {
+	p.MyVote = ""
+	if p.Revealed {
+		stats := planning.CalculateStats(p.Deck, p.Votes)
+		p.Stats = &stats
+	}
+	return svc.withTimerRemaining(p)
+}
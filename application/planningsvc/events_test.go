@@ -0,0 +1,269 @@
+package planningsvc
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"planning-poker/domain/planning"
+	"planning-poker/infra/in_memory"
+)
+
+// recordingSubscriber buffers the published events, so tests can wait for asynchronous ones
+type recordingSubscriber struct {
+	ch chan planning.Event
+}
+
+func newRecordingSubscriber() *recordingSubscriber {
+	return &recordingSubscriber{ch: make(chan planning.Event, 100)}
+}
+
+func (s *recordingSubscriber) Handle(event planning.Event) {
+	s.ch <- event
+}
+
+// drain returns the events published so far
+func (s *recordingSubscriber) drain() []planning.Event {
+	var events []planning.Event
+	for {
+		select {
+		case event := <-s.ch:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestPlanningService_VotePublishesVoteCastWithoutCard(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{
+		Id:          planningId,
+		Deck:        deck,
+		Players:     []planning.Player{{Id: "player1"}, {Id: "player2"}},
+		Votes:       map[string]string{"player1": ""},
+		HiddenVotes: map[string]string{"player1": "5"},
+	}
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: "player1", CardId: "5"}).Return(nil)
+
+	_, err := service.Vote(planningId, "player1", "5")
+
+	assert.NoError(t, err)
+	published := events.drain()
+	if assert.Len(t, published, 1) {
+		voteCast := published[0].(planning.VoteCast)
+		assert.Equal(t, "player1", voteCast.PlayerId)
+		assert.Empty(t, voteCast.Planning.MyVote)
+		assert.Equal(t, "", voteCast.Planning.Votes["player1"])
+	}
+}
+
+func TestPlanningService_VoteAutoRevealPublishesVoteCastAndVotesRevealed(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{
+		Id:          planningId,
+		Deck:        deck,
+		Settings:    planning.Settings{AutoReveal: true},
+		Players:     []planning.Player{{Id: "player1"}},
+		HiddenVotes: map[string]string{"player1": "5"},
+	}
+	revealed := plan
+	revealed.Revealed = true
+	revealed.Votes = plan.HiddenVotes
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: "player1", CardId: "5"}).Return(nil)
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(revealed, true, nil)
+
+	_, err := service.Vote(planningId, "player1", "5")
+
+	assert.NoError(t, err)
+	published := events.drain()
+	if assert.Len(t, published, 2) {
+		assert.IsType(t, planning.VoteCast{}, published[0])
+		assert.IsType(t, planning.VotesRevealed{}, published[1])
+		assert.NotNil(t, published[1].(planning.VotesRevealed).Planning.Stats)
+	}
+}
+
+func TestPlanningService_FailedCommandPublishesNothing(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Deck: deck, Owner: planning.Player{Id: "owner"}, Players: []planning.Player{{Id: "player1"}}}, nil)
+
+	_, err := service.Vote(planningId, "player1", "not a card")
+	assert.ErrorIs(t, err, planning.ErrInvalidVote)
+	err = service.ResetVotes(planningId, "player1")
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+
+	assert.Empty(t, events.drain())
+}
+
+func TestPlanningService_RevealRevealedPlanningPublishesNothing(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}, Revealed: true}, nil)
+
+	p, err := service.RevealVotes(planningId, "owner")
+
+	assert.NoError(t, err)
+	assert.True(t, p.Revealed)
+	assert.Empty(t, events.drain())
+	mockRepo.AssertNotCalled(t, "RevealVotes", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_AutoRevealRevealedMeanwhilePublishesNoVotesRevealed(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{
+		Id:          planningId,
+		Deck:        deck,
+		Settings:    planning.Settings{AutoReveal: true},
+		Players:     []planning.Player{{Id: "player1"}},
+		HiddenVotes: map[string]string{"player1": "5"},
+	}
+	revealed := plan
+	revealed.Revealed = true
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: "player1", CardId: "5"}).Return(nil)
+	// The vote of another player revealed the round in between
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(revealed, false, nil)
+
+	p, err := service.Vote(planningId, "player1", "5")
+
+	assert.NoError(t, err)
+	assert.True(t, p.Revealed)
+	published := events.drain()
+	if assert.Len(t, published, 1) {
+		assert.IsType(t, planning.VoteCast{}, published[0])
+	}
+}
+
+func TestPlanningService_ConcurrentLastVotesPublishOneVotesRevealed(t *testing.T) {
+	// The race is between the votes and the lock of the repository, so a real repository is used
+	repo := in_memory.NewPlanningRepository()
+	service := NewPlanningService(repo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	const players = 8
+	owner := planning.Player{Name: "owner"}
+	p := &planning.Planning{Owner: owner, Settings: planning.Settings{AutoReveal: true}}
+	assert.NoError(t, service.Create(p))
+	ids := []string{p.Owner.Id}
+	for i := 1; i < players; i++ {
+		player := planning.Player{Name: fmt.Sprintf("player %d", i)}
+		_, err := service.Join(p.Id, &player)
+		assert.NoError(t, err)
+		ids = append(ids, player.Id)
+	}
+
+	for round := range 20 {
+		var wg sync.WaitGroup
+		for _, id := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := service.Vote(p.Id, id, "5")
+				if err != nil {
+					// Votes arriving after the reveal are rejected
+					assert.ErrorIs(t, err, planning.ErrRoundRevealed)
+				}
+			}()
+		}
+		wg.Wait()
+
+		reveals := 0
+		for _, event := range events.drain() {
+			if _, ok := event.(planning.VotesRevealed); ok {
+				reveals++
+			}
+		}
+		assert.Equal(t, 1, reveals, "round %d", round)
+		assert.NoError(t, service.ResetVotes(p.Id, p.Owner.Id))
+	}
+}
+
+func TestPlanningService_LeaveOfOwnerPublishesOwnerChanged(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	owner := planning.Player{Id: "owner"}
+	guest := planning.Player{Id: "guest"}
+	left := planning.Planning{Id: planningId, Owner: guest, Players: []planning.Player{guest}}
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: owner, Players: []planning.Player{owner, guest}}, nil)
+	mockRepo.On("Leave", planningId, "owner").Return(left, nil)
+
+	_, err := service.Leave(planningId, "owner")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []planning.Event{
+		planning.PlayerLeft{Planning: left, PlayerId: "owner"},
+		planning.OwnerChanged{Planning: left, Owner: guest},
+	}, events.drain())
+}
+
+func TestPlanningService_LeaveOfLastPlayerPublishesPlanningClosed(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	owner := planning.Player{Id: "owner"}
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: owner, Players: []planning.Player{owner}}, nil)
+	mockRepo.On("Leave", planningId, "owner").Return(planning.Planning{}, nil)
+
+	_, err := service.Leave(planningId, "owner")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []planning.Event{planning.PlanningClosed{Id: planningId, Reason: planning.ClosedAbandoned}}, events.drain())
+}
+
+func TestPlanningService_ClosePublishesPlanningClosed(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+	mockRepo.On("Close", planningId).Return()
+
+	err := service.Close(planningId, "owner")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []planning.Event{planning.PlanningClosed{Id: planningId, Reason: planning.ClosedByModerator}}, events.drain())
+}
@@ -1,34 +1,103 @@
 package planningsvc
 
 import (
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"html"
 	"planning-poker/domain/planning"
 	"planning-poker/infra"
+	"sync"
+	"time"
 )
 
 type PlanningService struct {
 	planningRepository planning.Repository
 	logger             *zap.Logger
+	tokenSecret        []byte
+	gracePeriod        time.Duration
+	presenceMu         sync.Mutex
+	connections        map[presenceKey]int         // open connections per planning and player
+	pendingLeaves      map[presenceKey]*time.Timer // players that lost their last connection
+	timersMu           sync.Mutex
+	timers             map[string]*time.Timer // round timers per planning
+	subscribersMu      sync.RWMutex
+	subscribers        []planning.Subscriber
 }
 
-func NewPlanningService(planningRepository planning.Repository) *PlanningService {
-	return &PlanningService{
+type Option func(*PlanningService)
+
+// WithTokenSecret sets the secret used to sign reconnect tokens. Without it a random
+// secret is generated, so tokens become invalid when the server restarts.
+func WithTokenSecret(secret []byte) Option {
+	return func(svc *PlanningService) {
+		svc.tokenSecret = secret
+	}
+}
+
+// WithGracePeriod sets how long a disconnected player is kept before they are removed.
+// It panics unless the period is positive.
+func WithGracePeriod(d time.Duration) Option {
+	if d <= 0 {
+		panic(fmt.Sprintf("planningsvc: grace period must be positive, got %v", d))
+	}
+	return func(svc *PlanningService) {
+		svc.gracePeriod = d
+	}
+}
+
+func NewPlanningService(planningRepository planning.Repository, opts ...Option) *PlanningService {
+	svc := &PlanningService{
 		planningRepository: planningRepository,
 		logger:             infra.GetLogger(),
+		gracePeriod:        30 * time.Second,
+		connections:        make(map[presenceKey]int),
+		pendingLeaves:      make(map[presenceKey]*time.Timer),
+		timers:             make(map[string]*time.Timer),
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	if len(svc.tokenSecret) == 0 {
+		svc.tokenSecret = make([]byte, 32)
+		// rand.Read never returns an error, it crashes the program instead
+		_, _ = rand.Read(svc.tokenSecret)
 	}
+	return svc
 }
 
-// Create creates a new planning
+// Create creates a new planning. Only the owner, deck and settings are taken from p, every
+// planning starts without players, stories or votes.
 func (svc *PlanningService) Create(p *planning.Planning) error {
 	svc.logger.Debug("Creating new planning", zap.String("owner", p.Owner.Name))
 	if p.Id == "" {
 		p.Id = uuid.NewString()
 	}
-	p.Votes = make(map[string]int)
-	p.HiddenVotes = make(map[string]int)
-	err := svc.planningRepository.Create(*p)
+	if _, err := joinRole(p.Owner.Role); err != nil {
+		svc.logger.Warn("Owner tried to create planning with invalid role", zap.String("role", string(p.Owner.Role)))
+		return err
+	}
+	deck, err := planning.NewDeck(p.Deck.Type, p.Deck.Cards)
+	if err != nil {
+		svc.logger.Error("Error creating deck", zap.String("deckType", string(p.Deck.Type)), zap.Error(err))
+		return err
+	}
+	p.Deck = deck
+	p.Players = nil
+	p.Stories = nil
+	p.CurrentStoryId = ""
+	p.Revealed = false
+	p.MyVote = ""
+	p.Votes = make(map[string]string)
+	p.HiddenVotes = make(map[string]string)
+	p.Stats = nil
+	p.Timer = nil
+	p.Version = 0
+	p.CreatedAt = time.Now()
+	p.LastConnected = p.CreatedAt
+	err = svc.planningRepository.Create(*p)
 	if err != nil {
 		svc.logger.Error("Error creating planning", zap.Error(err))
 		return err
@@ -51,11 +120,8 @@ func (svc *PlanningService) GetById(id string, playerId string) (planning.Planni
 		svc.logger.Error("Error retrieving planning", zap.String("id", id), zap.Error(err))
 		return p, err
 	}
-	myVote, ok := p.HiddenVotes[playerId]
-	if !ok {
-		myVote = -1
-	}
-	p.MyVote = myVote
+	p = svc.shared(p)
+	p.MyVote = p.HiddenVotes[playerId]
 	svc.logger.Debug("Planning retrieved successfully", zap.String("id", p.Id))
 	return p, nil
 }
@@ -65,75 +131,223 @@ func (svc *PlanningService) Join(planningId string, player *planning.Player) (pl
 	svc.logger.Debug("Player joining planning", zap.String("planningId", planningId), zap.String("playerName", player.Name))
 	player.Name = html.EscapeString(player.Name)
 	player.Id = uuid.NewString()
+	role, err := joinRole(player.Role)
+	if err != nil {
+		svc.logger.Warn("Player tried to join with invalid role", zap.String("planningId", planningId), zap.String("role", string(player.Role)))
+		return planning.Planning{}, err
+	}
+	player.Role = role
 	p, err := svc.planningRepository.Join(planningId, *player)
 	if err != nil {
 		svc.logger.Error("Error joining planning", zap.String("planningId", planningId), zap.String("playerName", player.Name), zap.Error(err))
 		return planning.Planning{}, err
 	}
 	svc.logger.Debug("Player joined successfully", zap.String("planningId", planningId), zap.String("playerName", player.Id))
+	svc.touch(planningId)
+	svc.publish(planning.PlayerJoined{Planning: svc.shared(p), Player: *player})
 	return p, nil
 }
 
-// Leave allows a player to leave a planning
+// joinRole validates the role a player picked when joining. Facilitators are appointed by the owner.
+func joinRole(role planning.Role) (planning.Role, error) {
+	switch role {
+	case "":
+		return planning.RoleVoter, nil
+	case planning.RoleVoter, planning.RoleObserver:
+		return role, nil
+	case planning.RoleFacilitator:
+		return "", planning.ErrForbidden
+	default:
+		return "", planning.ErrInvalidRole
+	}
+}
+
+// Leave allows a player to leave a planning. The planning is deleted when the last player leaves.
 func (svc *PlanningService) Leave(planningId string, playerId string) (planning.Planning, error) {
 	svc.logger.Debug("Player leaving planning", zap.String("planningId", planningId), zap.String("playerId", playerId))
+	before, err := svc.planningRepository.GetById(planningId)
+	if err != nil {
+		svc.logger.Error("Error retrieving planning for leaving", zap.String("planningId", planningId), zap.Error(err))
+		return planning.Planning{}, err
+	}
 	p, err := svc.planningRepository.Leave(planningId, playerId)
 	if err != nil {
 		svc.logger.Error("Error leaving planning", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.Error(err))
 		return planning.Planning{}, err
 	}
 	svc.logger.Debug("Player left successfully", zap.String("planningId", planningId), zap.String("playerId", playerId))
+	if p.Id == "" {
+		svc.logger.Debug("Last player left, planning is gone", zap.String("planningId", planningId))
+		svc.stopTimer(planningId)
+		svc.publish(planning.PlanningClosed{Id: planningId, Reason: planning.ClosedAbandoned})
+		return p, nil
+	}
+	svc.publish(planning.PlayerLeft{Planning: svc.shared(p), PlayerId: playerId})
+	if p.Owner.Id != before.Owner.Id {
+		svc.publish(planning.OwnerChanged{Planning: svc.shared(p), Owner: p.Owner})
+	}
 	return p, nil
 }
 
-// Vote allows a player to vote on a planning
-func (svc *PlanningService) Vote(planningId string, playerId string, value int) error {
-	svc.logger.Debug("Player voting on planning", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.Int("value", value))
+// SetRole changes the role of a player. Only the owner may hand out roles, which is the
+// only way to become a facilitator.
+func (svc *PlanningService) SetRole(planningId string, ownerId string, playerId string, role planning.Role) (planning.Planning, error) {
+	svc.logger.Debug("Changing role of player", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.String("role", string(role)))
+	switch role {
+	case planning.RoleVoter, planning.RoleObserver, planning.RoleFacilitator:
+	default:
+		return planning.Planning{}, planning.ErrInvalidRole
+	}
+	plan, err := svc.planningRepository.GetById(planningId)
+	if err != nil {
+		svc.logger.Error("Error retrieving planning for role change", zap.String("planningId", planningId), zap.Error(err))
+		return planning.Planning{}, err
+	}
+	if plan.Owner.Id != ownerId {
+		svc.logger.Warn("Player is not allowed to change roles", zap.String("planningId", planningId), zap.String("playerId", ownerId))
+		return planning.Planning{}, planning.ErrForbidden
+	}
+	p, err := svc.planningRepository.SetRole(planningId, playerId, role)
+	if err != nil {
+		svc.logger.Error("Error changing role", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.Error(err))
+		return planning.Planning{}, err
+	}
+	svc.logger.Debug("Role changed successfully", zap.String("planningId", planningId), zap.String("playerId", playerId))
+	svc.touch(planningId)
+	svc.publish(planning.RoleChanged{Planning: svc.shared(p), PlayerId: playerId, Role: role})
+	return p, nil
+}
+
+// Vote allows a player to vote on a planning. With auto reveal enabled the round is
+// revealed as soon as every voter has voted, the returned planning tells if that happened.
+func (svc *PlanningService) Vote(planningId string, playerId string, value string) (planning.Planning, error) {
+	svc.logger.Debug("Player voting on planning", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.String("value", value))
 	plan, err := svc.planningRepository.GetById(planningId)
 	if err != nil {
 		svc.logger.Error("Error retrieving planning for voting", zap.String("planningId", planningId), zap.Error(err))
-		return err
+		return planning.Planning{}, err
 	}
 	if plan.Revealed {
-		return nil
+		svc.logger.Debug("Player tried to vote after reveal", zap.String("planningId", planningId), zap.String("playerId", playerId))
+		return planning.Planning{}, planning.ErrRoundRevealed
+	}
+	player, ok := plan.Player(playerId)
+	if !ok {
+		svc.logger.Warn("Unknown player tried to vote", zap.String("planningId", planningId), zap.String("playerId", playerId))
+		return planning.Planning{}, planning.ErrUnknownPlayer
+	}
+	if !player.CanVote() {
+		svc.logger.Warn("Observer tried to vote", zap.String("planningId", planningId), zap.String("playerId", playerId))
+		return planning.Planning{}, planning.ErrCannotVote
+	}
+	if _, ok := plan.Deck.Card(value); !ok {
+		svc.logger.Warn("Card is not part of the deck", zap.String("planningId", planningId), zap.String("value", value))
+		return planning.Planning{}, planning.ErrInvalidVote
+	}
+	err = svc.planningRepository.Vote(planningId, planning.Vote{PlayerId: playerId, CardId: value})
+	if errors.Is(err, planning.ErrRoundRevealed) {
+		// The round was revealed after it was read above
+		svc.logger.Debug("Player tried to vote after reveal", zap.String("planningId", planningId), zap.String("playerId", playerId))
+		return planning.Planning{}, err
 	}
-	err = svc.planningRepository.Vote(planningId, playerId, value)
 	if err != nil {
-		svc.logger.Error("Error recording vote", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.Int("value", value), zap.Error(err))
-		return err
+		svc.logger.Error("Error recording vote", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.String("value", value), zap.Error(err))
+		return planning.Planning{}, err
 	}
-	svc.logger.Debug("Vote recorded successfully", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.Int("value", value))
-	return nil
+	svc.logger.Debug("Vote recorded successfully", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.String("value", value))
+	plan, err = svc.planningRepository.GetById(planningId)
+	if err != nil {
+		svc.logger.Error("Error retrieving planning after voting", zap.String("planningId", planningId), zap.Error(err))
+		return planning.Planning{}, err
+	}
+	svc.touch(planningId)
+	svc.publish(planning.VoteCast{Planning: svc.shared(plan), PlayerId: playerId})
+	if plan.Settings.AutoReveal && !plan.Revealed && plan.EveryoneVoted() {
+		svc.logger.Debug("Everyone voted, revealing automatically", zap.String("planningId", planningId))
+		return svc.reveal(planningId)
+	}
+	return plan, nil
 }
 
 // RevealVotes reveals the votes for a planning
-func (svc *PlanningService) RevealVotes(planningId string) (planning.Planning, error) {
-	svc.logger.Debug("Revealing votes for planning", zap.String("planningId", planningId))
-	p, err := svc.planningRepository.RevealVotes(planningId)
+func (svc *PlanningService) RevealVotes(planningId string, playerId string) (planning.Planning, error) {
+	svc.logger.Debug("Revealing votes for planning", zap.String("planningId", planningId), zap.String("playerId", playerId))
+	p, err := svc.authorizeModerator(planningId, playerId)
+	if err != nil {
+		return planning.Planning{}, err
+	}
+	if p.Revealed {
+		return svc.shared(p), nil
+	}
+	return svc.reveal(planningId)
+}
+
+// reveal reveals the votes without checking who asked for it. Of concurrent reveals, e.g. by the
+// last two votes, only the one that revealed the round records it and publishes VotesRevealed.
+func (svc *PlanningService) reveal(planningId string) (planning.Planning, error) {
+	p, revealed, err := svc.planningRepository.RevealVotes(planningId, time.Now())
 	if err != nil {
 		svc.logger.Error("Error revealing votes", zap.String("planningId", planningId), zap.Error(err))
 		return p, err
 	}
+	p = svc.shared(p)
+	if !revealed {
+		svc.logger.Debug("Votes were revealed already", zap.String("planningId", planningId))
+		return p, nil
+	}
+	svc.stopTimer(planningId) // the repository stopped the timer with the round
 	svc.logger.Debug("Votes revealed successfully", zap.String("planningId", p.Id))
+	svc.touch(planningId)
+	svc.publish(planning.VotesRevealed{Planning: p})
 	return p, nil
 }
 
 // ResetVotes resets the votes for a planning
-func (svc *PlanningService) ResetVotes(planningId string) error {
-	svc.logger.Debug("Resetting votes for planning", zap.String("planningId", planningId))
+func (svc *PlanningService) ResetVotes(planningId string, playerId string) error {
+	svc.logger.Debug("Resetting votes for planning", zap.String("planningId", planningId), zap.String("playerId", playerId))
+	if _, err := svc.authorizeModerator(planningId, playerId); err != nil {
+		return err
+	}
 	err := svc.planningRepository.ResetVotes(planningId)
 	if err != nil {
 		svc.logger.Error("Error resetting votes", zap.String("planningId", planningId), zap.Error(err))
 		return err
 	}
+	svc.stopTimer(planningId)
 	svc.logger.Debug("Votes reset successfully", zap.String("planningId", planningId))
+	p, err := svc.planningRepository.GetById(planningId)
+	if err != nil {
+		svc.logger.Error("Error retrieving planning after reset", zap.String("planningId", planningId), zap.Error(err))
+		return err
+	}
+	svc.touch(planningId)
+	svc.publish(planning.RoundReset{Planning: svc.shared(p)})
 	return nil
 }
 
 // Close closes a planning
-func (svc *PlanningService) Close(planningId string) error {
-	svc.logger.Debug("Closing planning", zap.String("planningId", planningId))
+func (svc *PlanningService) Close(planningId string, playerId string) error {
+	svc.logger.Debug("Closing planning", zap.String("planningId", planningId), zap.String("playerId", playerId))
+	if _, err := svc.authorizeModerator(planningId, playerId); err != nil {
+		return err
+	}
 	svc.planningRepository.Close(planningId)
+	svc.stopTimer(planningId)
 	svc.logger.Debug("Planning closed successfully", zap.String("planningId", planningId))
+	svc.publish(planning.PlanningClosed{Id: planningId, Reason: planning.ClosedByModerator})
 	return nil
 }
+
+// authorizeModerator makes sure the player is allowed to moderate the planning and returns it
+func (svc *PlanningService) authorizeModerator(planningId string, playerId string) (planning.Planning, error) {
+	p, err := svc.planningRepository.GetById(planningId)
+	if err != nil {
+		svc.logger.Error("Error retrieving planning for authorization", zap.String("planningId", planningId), zap.Error(err))
+		return planning.Planning{}, err
+	}
+	if !p.CanModerate(playerId) {
+		svc.logger.Warn("Player is not allowed to moderate planning", zap.String("planningId", planningId), zap.String("playerId", playerId))
+		return planning.Planning{}, planning.ErrForbidden
+	}
+	return p, nil
+}
@@ -7,12 +7,20 @@ import (
 	"github.com/stretchr/testify/mock"
 	"planning-poker/domain/planning"
 	"testing"
+	"time"
 )
 
 type MockPlanningRepository struct {
 	mock.Mock
 }
 
+// newMockRepository returns a mock that accepts the activity every change of a planning records
+func newMockRepository() *MockPlanningRepository {
+	m := new(MockPlanningRepository)
+	m.On("Touch", mock.Anything, mock.AnythingOfType("time.Time")).Return(nil).Maybe()
+	return m
+}
+
 func (m *MockPlanningRepository) Create(p planning.Planning) error {
 	args := m.Called(p)
 	return args.Error(0)
@@ -23,31 +31,82 @@ func (m *MockPlanningRepository) GetById(id string) (planning.Planning, error) {
 	return args.Get(0).(planning.Planning), args.Error(1)
 }
 
-func (m *MockPlanningRepository) Join(planningId string, player planning.Player) error {
+func (m *MockPlanningRepository) GetAll() ([]planning.Planning, error) {
+	args := m.Called()
+	return args.Get(0).([]planning.Planning), args.Error(1)
+}
+
+func (m *MockPlanningRepository) Join(planningId string, player planning.Player) (planning.Planning, error) {
 	args := m.Called(planningId, player)
-	return args.Error(0)
+	return args.Get(0).(planning.Planning), args.Error(1)
 }
 
-func (m *MockPlanningRepository) Vote(planningId string, playerId string, value int) {
-	m.Called(planningId, playerId, value)
+func (m *MockPlanningRepository) Leave(planningId string, playerId string) (planning.Planning, error) {
+	args := m.Called(planningId, playerId)
+	return args.Get(0).(planning.Planning), args.Error(1)
 }
 
-func (m *MockPlanningRepository) RevealVotes(planningId string) (planning.Planning, error) {
-	args := m.Called(planningId)
+func (m *MockPlanningRepository) SetRole(planningId string, playerId string, role planning.Role) (planning.Planning, error) {
+	args := m.Called(planningId, playerId, role)
 	return args.Get(0).(planning.Planning), args.Error(1)
 }
 
+func (m *MockPlanningRepository) Vote(planningId string, vote planning.Vote) error {
+	args := m.Called(planningId, vote)
+	return args.Error(0)
+}
+
+func (m *MockPlanningRepository) Touch(planningId string, at time.Time) error {
+	args := m.Called(planningId, at)
+	return args.Error(0)
+}
+
+func (m *MockPlanningRepository) DeleteIdle(idleSince time.Time) ([]string, error) {
+	args := m.Called(idleSince)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockPlanningRepository) RevealVotes(planningId string, revealedAt time.Time) (planning.Planning, bool, error) {
+	args := m.Called(planningId, revealedAt)
+	return args.Get(0).(planning.Planning), args.Bool(1), args.Error(2)
+}
+
 func (m *MockPlanningRepository) ResetVotes(planningId string) error {
 	args := m.Called(planningId)
 	return args.Error(0)
 }
 
+func (m *MockPlanningRepository) AddStory(planningId string, story planning.Story) (planning.Planning, error) {
+	args := m.Called(planningId, story)
+	return args.Get(0).(planning.Planning), args.Error(1)
+}
+
+func (m *MockPlanningRepository) SetCurrentStory(planningId string, storyId string) (planning.Planning, error) {
+	args := m.Called(planningId, storyId)
+	return args.Get(0).(planning.Planning), args.Error(1)
+}
+
+func (m *MockPlanningRepository) SetEstimate(planningId string, storyId string, estimate string) (planning.Planning, error) {
+	args := m.Called(planningId, storyId, estimate)
+	return args.Get(0).(planning.Planning), args.Error(1)
+}
+
+func (m *MockPlanningRepository) SetTimer(planningId string, timer *planning.Timer) (planning.Planning, error) {
+	args := m.Called(planningId, timer)
+	return args.Get(0).(planning.Planning), args.Error(1)
+}
+
+func (m *MockPlanningRepository) TouchPlayer(planningId string, playerId string, at time.Time) error {
+	args := m.Called(planningId, playerId, at)
+	return args.Error(0)
+}
+
 func (m *MockPlanningRepository) Close(planningId string) {
 	m.Called(planningId)
 }
 
 func TestPlanningService_Create(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	p := &planning.Planning{
@@ -55,17 +114,82 @@ func TestPlanningService_Create(t *testing.T) {
 	}
 
 	mockRepo.On("Create", mock.AnythingOfType("planning.Planning")).Return(nil)
-	mockRepo.On("Join", mock.AnythingOfType("string"), mock.AnythingOfType("planning.Player")).Return(nil)
+	mockRepo.On("Join", mock.AnythingOfType("string"), mock.AnythingOfType("planning.Player")).Return(planning.Planning{}, nil)
 
 	err := service.Create(p)
 
 	assert.NoError(t, err)
 	assert.NotEmpty(t, p.Id)
+	assert.Equal(t, planning.DeckFibonacci, p.Deck.Type)
+	assert.WithinDuration(t, time.Now(), p.CreatedAt, time.Second)
+	assert.Equal(t, p.CreatedAt, p.LastConnected)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestPlanningService_CreateResetsState(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	p := &planning.Planning{
+		Owner:          planning.Player{Name: "test-owner"},
+		Players:        []planning.Player{{Id: "ghost", Name: "ghost"}},
+		Stories:        []planning.Story{{Id: "story1", Title: "injected"}},
+		CurrentStoryId: "story1",
+		Revealed:       true,
+		Stats:          &planning.RoundStats{Count: 1},
+		Timer:          &planning.Timer{Deadline: time.Now().Add(time.Hour), AutoReveal: true},
+		Version:        42,
+	}
+
+	mockRepo.On("Create", mock.MatchedBy(func(created planning.Planning) bool {
+		return created.Players == nil && created.Stories == nil && created.CurrentStoryId == "" &&
+			!created.Revealed && created.Stats == nil && created.Timer == nil && created.Version == 0
+	})).Return(nil)
+	mockRepo.On("Join", mock.AnythingOfType("string"), mock.AnythingOfType("planning.Player")).Return(planning.Planning{}, nil)
+
+	err := service.Create(p)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_CreateWithDeck(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	p := &planning.Planning{
+		Owner: planning.Player{Name: "test-owner"},
+		Deck:  planning.Deck{Type: planning.DeckTShirt},
+	}
+
+	mockRepo.On("Create", mock.AnythingOfType("planning.Planning")).Return(nil)
+	mockRepo.On("Join", mock.AnythingOfType("string"), mock.AnythingOfType("planning.Player")).Return(planning.Planning{}, nil)
+
+	err := service.Create(p)
+
+	assert.NoError(t, err)
+	_, ok := p.Deck.Card("XL")
+	assert.True(t, ok)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_CreateUnknownDeck(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	p := &planning.Planning{
+		Owner: planning.Player{Name: "test-owner"},
+		Deck:  planning.Deck{Type: "tarot"},
+	}
+
+	err := service.Create(p)
+
+	assert.ErrorIs(t, err, planning.ErrInvalidDeck)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
 func TestPlanningService_CreateErr(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	p := &planning.Planning{
@@ -81,145 +205,649 @@ func TestPlanningService_CreateErr(t *testing.T) {
 }
 
 func TestPlanningService_GetById(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
-	expectedPlanning := planning.Planning{Id: "test-id"}
+	expectedPlanning := planning.Planning{Id: "test-id", HiddenVotes: map[string]string{"player1": "5"}}
 	mockRepo.On("GetById", "test-id").Return(expectedPlanning, nil)
 
-	p, err := service.GetById("test-id")
+	p, err := service.GetById("test-id", "player1")
 
 	assert.NoError(t, err)
-	assert.Equal(t, expectedPlanning, p)
+	assert.Equal(t, "test-id", p.Id)
+	assert.Equal(t, "5", p.MyVote)
+	assert.Nil(t, p.Stats)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestPlanningService_GetByIdRevealedHasStats(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	revealedPlanning := planning.Planning{Id: "test-id", Deck: deck, Revealed: true, Votes: map[string]string{"player1": "5"}}
+	mockRepo.On("GetById", "test-id").Return(revealedPlanning, nil)
+
+	p, err := service.GetById("test-id", "")
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, p.Stats) {
+		assert.True(t, p.Stats.Consensus)
+	}
+}
+
 func TestPlanningService_GetByIdErr(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	mockRepo.On("GetById", "test-id").Return(planning.Planning{}, errors.New("not found"))
 
-	_, err := service.GetById("test-id")
+	_, err := service.GetById("test-id", "")
 
 	assert.Error(t, err)
 	mockRepo.AssertExpectations(t)
 }
 
 func TestPlanningService_Join(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	player := planning.Player{Name: "test-player"}
+	planningId := uuid.NewString()
+
+	mockRepo.On("Join", planningId, mock.AnythingOfType("planning.Player")).Return(planning.Planning{Id: planningId}, nil)
+
+	p, err := service.Join(planningId, &player)
+
+	assert.NoError(t, err)
+	assert.Equal(t, planningId, p.Id)
+	assert.NotEmpty(t, player.Id)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_JoinAsObserver(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	player := planning.Player{Name: "test-player", Role: planning.RoleObserver}
+	planningId := uuid.NewString()
+
+	mockRepo.On("Join", planningId, mock.MatchedBy(func(p planning.Player) bool { return p.Role == planning.RoleObserver })).Return(planning.Planning{Id: planningId}, nil)
+
+	_, err := service.Join(planningId, &player)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_JoinDefaultsToVoter(t *testing.T) {
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	player := planning.Player{Name: "test-player"}
 	planningId := uuid.NewString()
 
-	mockRepo.On("Join", planningId, mock.AnythingOfType("planning.Player")).Return(nil)
+	mockRepo.On("Join", planningId, mock.AnythingOfType("planning.Player")).Return(planning.Planning{Id: planningId}, nil)
+
+	_, err := service.Join(planningId, &player)
+
+	assert.NoError(t, err)
+	assert.Equal(t, planning.RoleVoter, player.Role)
+}
+
+func TestPlanningService_JoinAsFacilitator(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	player := planning.Player{Name: "test-player", Role: planning.RoleFacilitator}
+
+	_, err := service.Join(uuid.NewString(), &player)
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "Join", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_JoinInvalidRole(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	player := planning.Player{Name: "test-player", Role: "king"}
+
+	_, err := service.Join(uuid.NewString(), &player)
+
+	assert.ErrorIs(t, err, planning.ErrInvalidRole)
+	mockRepo.AssertNotCalled(t, "Join", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_SetRole(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+	mockRepo.On("SetRole", planningId, "player1", planning.RoleFacilitator).Return(planning.Planning{Id: planningId}, nil)
 
-	err := service.Join(planningId, player)
+	_, err := service.SetRole(planningId, "owner", "player1", planning.RoleFacilitator)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestPlanningService_SetRoleNotOwner(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+
+	_, err := service.SetRole(planningId, "player1", "player1", planning.RoleFacilitator)
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "SetRole", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_SetRoleInvalid(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	_, err := service.SetRole(uuid.NewString(), "owner", "player1", "king")
+
+	assert.ErrorIs(t, err, planning.ErrInvalidRole)
+	mockRepo.AssertNotCalled(t, "GetById", mock.Anything)
+}
+
 func TestPlanningService_JoinErr(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	player := planning.Player{Name: "test-player"}
 	planningId := uuid.NewString()
 
-	mockRepo.On("Join", planningId, mock.AnythingOfType("planning.Player")).Return(errors.New("join error"))
+	mockRepo.On("Join", planningId, mock.AnythingOfType("planning.Player")).Return(planning.Planning{}, errors.New("join error"))
 
-	err := service.Join(planningId, player)
+	_, err := service.Join(planningId, &player)
 
 	assert.Error(t, err)
 	mockRepo.AssertExpectations(t)
 }
 
 func TestPlanningService_Vote(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	planningId := uuid.NewString()
 	playerId := uuid.NewString()
-	value := 5
+	value := "5"
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	players := []planning.Player{{Id: playerId}}
 
-	mockRepo.On("Vote", planningId, playerId, value).Return()
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Deck: deck, Players: players}, nil)
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: playerId, CardId: value}).Return(nil)
 
-	err := service.Vote(planningId, playerId, value)
+	_, err := service.Vote(planningId, playerId, value)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestPlanningService_VoteAutoReveal(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{
+		Id:          planningId,
+		Deck:        deck,
+		Settings:    planning.Settings{AutoReveal: true},
+		Players:     []planning.Player{{Id: "player1"}, {Id: "player2"}, {Id: "observer", Role: planning.RoleObserver}},
+		HiddenVotes: map[string]string{"player2": "3"},
+	}
+	voted := plan
+	voted.HiddenVotes = map[string]string{"player1": "5", "player2": "3"}
+	revealed := voted
+	revealed.Revealed = true
+	revealed.Votes = voted.HiddenVotes
+
+	mockRepo.On("GetById", planningId).Return(plan, nil).Once()
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: "player1", CardId: "5"}).Return(nil)
+	mockRepo.On("GetById", planningId).Return(voted, nil).Once()
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(revealed, true, nil)
+
+	p, err := service.Vote(planningId, "player1", "5")
+
+	assert.NoError(t, err)
+	assert.True(t, p.Revealed)
+	assert.NotNil(t, p.Stats)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_VoteAutoRevealWaitsForEveryone(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{
+		Id:          planningId,
+		Deck:        deck,
+		Settings:    planning.Settings{AutoReveal: true},
+		Players:     []planning.Player{{Id: "player1"}, {Id: "player2"}},
+		HiddenVotes: map[string]string{"player1": "5"},
+	}
+
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: "player1", CardId: "5"}).Return(nil)
+
+	p, err := service.Vote(planningId, "player1", "5")
+
+	assert.NoError(t, err)
+	assert.False(t, p.Revealed)
+	mockRepo.AssertNotCalled(t, "RevealVotes", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_VoteCardNotInDeck(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	playerId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	players := []planning.Player{{Id: playerId}}
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Deck: deck, Players: players}, nil)
+
+	_, err := service.Vote(planningId, playerId, "XL")
+
+	assert.ErrorIs(t, err, planning.ErrInvalidVote)
+	mockRepo.AssertNotCalled(t, "Vote", planningId, planning.Vote{PlayerId: playerId, CardId: "XL"})
+}
+
+func TestPlanningService_VoteUnknownPlayer(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	players := []planning.Player{{Id: "player1"}}
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Deck: deck, Players: players}, nil)
+
+	_, err := service.Vote(planningId, "stranger", "5")
+
+	assert.ErrorIs(t, err, planning.ErrUnknownPlayer)
+	mockRepo.AssertNotCalled(t, "Vote", planningId, planning.Vote{PlayerId: "stranger", CardId: "5"})
+}
+
+func TestPlanningService_VoteObserver(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	players := []planning.Player{{Id: "player1", Role: planning.RoleObserver}}
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Deck: deck, Players: players}, nil)
+
+	_, err := service.Vote(planningId, "player1", "5")
+
+	assert.ErrorIs(t, err, planning.ErrCannotVote)
+	mockRepo.AssertNotCalled(t, "Vote", planningId, planning.Vote{PlayerId: "player1", CardId: "5"})
+}
+
+func TestPlanningService_VoteRevealed(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	playerId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Revealed: true}, nil)
+
+	_, err := service.Vote(planningId, playerId, "5")
+
+	assert.ErrorIs(t, err, planning.ErrRoundRevealed)
+	mockRepo.AssertNotCalled(t, "Vote", planningId, planning.Vote{PlayerId: playerId, CardId: "5"})
+}
+
+func TestPlanningService_VoteRacingRevealIsRejected(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{Id: planningId, Deck: deck, Players: []planning.Player{{Id: "player1"}}}
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	// The round was revealed between reading the planning and recording the vote
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: "player1", CardId: "5"}).Return(planning.ErrRoundRevealed)
+
+	_, err := service.Vote(planningId, "player1", "5")
+
+	assert.ErrorIs(t, err, planning.ErrRoundRevealed)
+	assert.Empty(t, events.drain(), "a rejected vote must not be published")
+}
+
 func TestPlanningService_RevealVotes(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	planningId := uuid.NewString()
-	expectedPlanning := planning.Planning{Id: planningId, Votes: map[string]int{"player1": 5}}
+	ownerId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	revealedPlanning := planning.Planning{Id: planningId, Deck: deck, Revealed: true, Votes: map[string]string{"player1": "5", "player2": "8"}}
 
-	mockRepo.On("RevealVotes", planningId).Return(expectedPlanning, nil)
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: ownerId}}, nil)
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(revealedPlanning, true, nil)
 
-	p, err := service.RevealVotes(planningId)
+	p, err := service.RevealVotes(planningId, ownerId)
 
 	assert.NoError(t, err)
-	assert.Equal(t, expectedPlanning, p)
+	assert.Equal(t, revealedPlanning.Votes, p.Votes)
+	if assert.NotNil(t, p.Stats) {
+		assert.Equal(t, 6.5, *p.Stats.Average)
+		assert.Equal(t, "8", p.Stats.NearestCard)
+	}
 	mockRepo.AssertExpectations(t)
 }
 
 func TestPlanningService_RevealVotesErr(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	planningId := uuid.NewString()
+	ownerId := uuid.NewString()
 
-	mockRepo.On("RevealVotes", planningId).Return(planning.Planning{}, errors.New("reveal error"))
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: ownerId}}, nil)
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(planning.Planning{}, false, errors.New("reveal error"))
 
-	_, err := service.RevealVotes(planningId)
+	_, err := service.RevealVotes(planningId, ownerId)
 
 	assert.Error(t, err)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestPlanningService_RevealVotesNotOwner(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+
+	_, err := service.RevealVotes(planningId, "player1")
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "RevealVotes", planningId, mock.Anything)
+}
+
+func TestPlanningService_RevealVotesFacilitator(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	plan := planning.Planning{
+		Id:      planningId,
+		Owner:   planning.Player{Id: "owner"},
+		Players: []planning.Player{{Id: "owner"}, {Id: "facilitator", Role: planning.RoleFacilitator}},
+	}
+
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(plan, true, nil)
+
+	_, err := service.RevealVotes(planningId, "facilitator")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
 func TestPlanningService_ResetVotes(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	planningId := uuid.NewString()
+	ownerId := uuid.NewString()
 
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: ownerId}}, nil)
 	mockRepo.On("ResetVotes", planningId).Return(nil)
 
-	err := service.ResetVotes(planningId)
+	err := service.ResetVotes(planningId, ownerId)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 }
 
 func TestPlanningService_ResetVotesErr(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	planningId := uuid.NewString()
+	ownerId := uuid.NewString()
 
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: ownerId}}, nil)
 	mockRepo.On("ResetVotes", planningId).Return(errors.New("reset error"))
 
-	err := service.ResetVotes(planningId)
+	err := service.ResetVotes(planningId, ownerId)
 
 	assert.Error(t, err)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestPlanningService_ResetVotesNotOwner(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+
+	err := service.ResetVotes(planningId, "player1")
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "ResetVotes", planningId)
+}
+
 func TestPlanningService_Close(t *testing.T) {
-	mockRepo := new(MockPlanningRepository)
+	mockRepo := newMockRepository()
 	service := NewPlanningService(mockRepo)
 
 	planningId := uuid.NewString()
+	ownerId := uuid.NewString()
 
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: ownerId}}, nil)
 	mockRepo.On("Close", planningId).Return()
 
-	err := service.Close(planningId)
+	err := service.Close(planningId, ownerId)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestPlanningService_CloseNotOwner(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+
+	err := service.Close(planningId, "player1")
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "Close", planningId)
+}
+
+func TestPlanningService_CloseNotFound(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{}, planning.ErrPlanningNotFound)
+
+	err := service.Close(planningId, "owner")
+
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+	mockRepo.AssertNotCalled(t, "Close", planningId)
+}
+
+func TestWithGracePeriod_MustBePositive(t *testing.T) {
+	assert.Panics(t, func() { WithGracePeriod(0) })
+	assert.Panics(t, func() { WithGracePeriod(-time.Second) })
+}
+
+func TestPlanningService_CreateAsFacilitator(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	err := service.Create(&planning.Planning{Owner: planning.Player{Name: "owner", Role: planning.RoleFacilitator}})
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestPlanningService_CreateJoinErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	mockRepo.On("Create", mock.AnythingOfType("planning.Planning")).Return(nil)
+	mockRepo.On("Join", mock.AnythingOfType("string"), mock.AnythingOfType("planning.Player")).Return(planning.Planning{}, errors.New("join error"))
+
+	err := service.Create(&planning.Planning{Owner: planning.Player{Name: "owner"}})
+
+	assert.Error(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_LeaveNotFound(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{}, errors.New("not found"))
+
+	_, err := service.Leave(planningId, "player1")
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "Leave", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_LeaveErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId}, nil)
+	mockRepo.On("Leave", planningId, "player1").Return(planning.Planning{}, errors.New("leave error"))
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	_, err := service.Leave(planningId, "player1")
+
+	assert.Error(t, err)
+	assert.Empty(t, events.drain())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_SetRoleNotFound(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{}, errors.New("not found"))
+
+	_, err := service.SetRole(planningId, "owner", "player1", planning.RoleObserver)
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "SetRole", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_SetRoleErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+	mockRepo.On("SetRole", planningId, "player1", planning.RoleObserver).Return(planning.Planning{}, errors.New("role error"))
+
+	_, err := service.SetRole(planningId, "owner", "player1", planning.RoleObserver)
+
+	assert.Error(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_VoteNotFound(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{}, errors.New("not found"))
+
+	_, err := service.Vote(planningId, "player1", "5")
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "Vote", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_VoteErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Deck: deck, Players: []planning.Player{{Id: "player1"}}}, nil)
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: "player1", CardId: "5"}).Return(errors.New("vote error"))
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	_, err := service.Vote(planningId, "player1", "5")
+
+	assert.Error(t, err)
+	assert.Empty(t, events.drain())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_VoteGoneAfterVoting(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Deck: deck, Players: []planning.Player{{Id: "player1"}}}, nil).Once()
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: "player1", CardId: "5"}).Return(nil)
+	mockRepo.On("GetById", planningId).Return(planning.Planning{}, errors.New("not found")).Once()
+
+	_, err := service.Vote(planningId, "player1", "5")
+
+	assert.Error(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_ResetVotesGoneAfterReset(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil).Once()
+	mockRepo.On("ResetVotes", planningId).Return(nil)
+	mockRepo.On("GetById", planningId).Return(planning.Planning{}, errors.New("not found")).Once()
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	err := service.ResetVotes(planningId, "owner")
+
+	assert.Error(t, err)
+	assert.Empty(t, events.drain())
+	mockRepo.AssertExpectations(t)
+}
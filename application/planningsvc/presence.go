@@ -0,0 +1,187 @@
+package planningsvc
+
+import (
+	"go.uber.org/zap"
+	"maps"
+	"planning-poker/domain/planning"
+	"slices"
+	"time"
+)
+
+// ReconnectToken returns a signed token that lets the player resume their seat after a disconnect.
+// The same token identifies the player on the REST API.
+func (svc *PlanningService) ReconnectToken(planningId string, playerId string) string {
+	return signToken(svc.tokenSecret, planningId, playerId)
+}
+
+// Authenticate returns the planning and player a token was issued for, without connecting the player
+func (svc *PlanningService) Authenticate(token string) (string, string, error) {
+	planningId, playerId, err := verifyToken(svc.tokenSecret, token)
+	if err != nil {
+		svc.logger.Warn("Invalid player token")
+		return "", "", err
+	}
+	return planningId, playerId, nil
+}
+
+// Connect registers an open connection of a player and cancels a pending removal
+func (svc *PlanningService) Connect(planningId string, playerId string) {
+	svc.connect(presenceKey{planningId: planningId, playerId: playerId})
+}
+
+// connect counts the connection under presenceMu and records the activity in the repository
+// afterwards, so connections of other plannings don't wait for the repository
+func (svc *PlanningService) connect(key presenceKey) {
+	svc.presenceMu.Lock()
+	svc.connections[key]++
+	if timer, ok := svc.pendingLeaves[key]; ok {
+		timer.Stop()
+		delete(svc.pendingLeaves, key)
+		svc.logger.Debug("Player reconnected in time", zap.String("planningId", key.planningId), zap.String("playerId", key.playerId))
+	}
+	svc.presenceMu.Unlock()
+	svc.touch(key.planningId)
+	svc.touchPlayer(key.planningId, key.playerId, time.Now())
+}
+
+// Disconnect unregisters a connection of a player. Once the last connection is gone the player
+// keeps their seat and hidden vote for the grace period and is removed afterwards, unless they
+// were seen on another instance in the meantime.
+func (svc *PlanningService) Disconnect(planningId string, playerId string) {
+	svc.release(presenceKey{planningId: planningId, playerId: playerId})
+	svc.touch(planningId)
+}
+
+// release uncounts a connection and schedules the removal of the player once it was the last one
+func (svc *PlanningService) release(key presenceKey) {
+	svc.presenceMu.Lock()
+	defer svc.presenceMu.Unlock()
+	svc.connections[key]--
+	if svc.connections[key] > 0 {
+		return
+	}
+	delete(svc.connections, key)
+	svc.scheduleLeave(key)
+}
+
+// scheduleLeave removes a player without connections once the grace period is over.
+// Must be called with presenceMu held.
+func (svc *PlanningService) scheduleLeave(key presenceKey) {
+	if _, ok := svc.pendingLeaves[key]; ok {
+		return
+	}
+	planningId, playerId := key.planningId, key.playerId
+	svc.logger.Debug("Player disconnected, waiting for reconnect", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.Duration("gracePeriod", svc.gracePeriod))
+	disconnectedAt := time.Now()
+	var timer *time.Timer
+	timer = time.AfterFunc(svc.gracePeriod, func() {
+		svc.presenceMu.Lock()
+		if svc.pendingLeaves[key] != timer {
+			svc.presenceMu.Unlock()
+			return
+		}
+		delete(svc.pendingLeaves, key)
+		svc.presenceMu.Unlock()
+		svc.leaveUnlessSeen(planningId, playerId, disconnectedAt)
+	})
+	svc.pendingLeaves[key] = timer
+}
+
+// leaveUnlessSeen removes a player that lost their last connection to this instance, unless
+// they connected again since, here or to another instance sharing the repository
+func (svc *PlanningService) leaveUnlessSeen(planningId string, playerId string, disconnectedAt time.Time) {
+	p, err := svc.planningRepository.GetById(planningId)
+	if err != nil {
+		svc.logger.Debug("Planning of disconnected player is gone", zap.String("planningId", planningId))
+		return
+	}
+	player, ok := p.Player(playerId)
+	if !ok {
+		return
+	}
+	if player.LastSeen.After(disconnectedAt) {
+		svc.logger.Debug("Disconnected player was seen since", zap.String("planningId", planningId), zap.String("playerId", playerId))
+		return
+	}
+	// Leave publishes the change and logs its errors
+	svc.Leave(planningId, playerId)
+}
+
+// StartHeartbeat keeps marking the players connected to this instance as seen, so other instances
+// sharing the repository don't remove them when they lose their own connections. Players are
+// marked several times per grace period. The returned function stops the heartbeat.
+func (svc *PlanningService) StartHeartbeat() func() {
+	ticker := time.NewTicker(svc.gracePeriod / 3)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				svc.heartbeat()
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+func (svc *PlanningService) heartbeat() {
+	// The time is taken before looking at the connections, so a player that disconnects
+	// meanwhile is never seen after their disconnect
+	now := time.Now()
+	svc.presenceMu.Lock()
+	keys := slices.Collect(maps.Keys(svc.connections))
+	svc.presenceMu.Unlock()
+	for _, key := range keys {
+		svc.touchPlayer(key.planningId, key.playerId, now)
+	}
+}
+
+// Resume reattaches a connection to the player identified by the reconnect token
+func (svc *PlanningService) Resume(token string) (planning.Planning, planning.Player, error) {
+	planningId, playerId, err := verifyToken(svc.tokenSecret, token)
+	if err != nil {
+		svc.logger.Warn("Invalid reconnect token")
+		return planning.Planning{}, planning.Player{}, err
+	}
+	svc.logger.Debug("Player resuming planning", zap.String("planningId", planningId), zap.String("playerId", playerId))
+	// Connecting first cancels a pending removal, so the player can't be removed after being read
+	key := presenceKey{planningId: planningId, playerId: playerId}
+	svc.connect(key)
+	p, err := svc.GetById(planningId, playerId)
+	if err != nil {
+		svc.release(key)
+		return planning.Planning{}, planning.Player{}, err
+	}
+	i := slices.IndexFunc(p.Players, func(player planning.Player) bool { return player.Id == playerId })
+	if i < 0 {
+		svc.release(key)
+		svc.logger.Warn("Player to resume is no longer part of the planning", zap.String("planningId", planningId), zap.String("playerId", playerId))
+		return planning.Planning{}, planning.Player{}, planning.ErrUnknownPlayer
+	}
+	svc.logger.Debug("Player resumed successfully", zap.String("planningId", planningId), zap.String("playerId", playerId))
+	return p, p.Players[i], nil
+}
+
+type presenceKey struct {
+	planningId string
+	playerId   string
+}
+
+// touchPlayer records that the player is connected to this instance
+func (svc *PlanningService) touchPlayer(planningId string, playerId string, at time.Time) {
+	if err := svc.planningRepository.TouchPlayer(planningId, playerId, at); err != nil {
+		svc.logger.Debug("Error recording that player was seen", zap.String("planningId", planningId), zap.String("playerId", playerId), zap.Error(err))
+	}
+}
+
+// touch records the activity used to expire abandoned plannings, every connect, disconnect and change counts
+func (svc *PlanningService) touch(planningId string) {
+	if err := svc.planningRepository.Touch(planningId, time.Now()); err != nil {
+		svc.logger.Debug("Error recording connection activity", zap.String("planningId", planningId), zap.Error(err))
+	}
+}
@@ -0,0 +1,310 @@
+package planningsvc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"planning-poker/domain/planning"
+)
+
+func TestToken_RoundTrip(t *testing.T) {
+	secret := []byte("secret")
+	token := signToken(secret, "planning.1", "player1")
+
+	planningId, playerId, err := verifyToken(secret, token)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "planning.1", planningId)
+	assert.Equal(t, "player1", playerId)
+}
+
+func TestToken_Invalid(t *testing.T) {
+	secret := []byte("secret")
+	token := signToken(secret, "planning1", "player1")
+	forged := signToken([]byte("other"), "planning1", "player1")
+
+	for _, tok := range []string{"", "a.b", "a.b.c", "!.!.!", "YQ.!.YQ", "YQ.YQ.!", forged, token + "x"} {
+		_, _, err := verifyToken(secret, tok)
+		assert.ErrorIs(t, err, planning.ErrInvalidToken, tok)
+	}
+}
+
+func TestPlanningService_Authenticate(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo, WithTokenSecret([]byte("secret")))
+
+	planningId, playerId, err := service.Authenticate(service.ReconnectToken("planning1", "player1"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "planning1", planningId)
+	assert.Equal(t, "player1", playerId)
+	mockRepo.AssertNotCalled(t, "Touch", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_AuthenticateInvalidToken(t *testing.T) {
+	service := NewPlanningService(new(MockPlanningRepository), WithTokenSecret([]byte("secret")))
+
+	_, _, err := service.Authenticate(signToken([]byte("other"), "planning1", "player1"))
+
+	assert.ErrorIs(t, err, planning.ErrInvalidToken)
+}
+
+func TestPlanningService_DisconnectLeavesAfterGracePeriod(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo, WithGracePeriod(10*time.Millisecond))
+	mockRepo.On("Touch", "planning1", mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("TouchPlayer", "planning1", "player1", mock.AnythingOfType("time.Time")).Return(nil)
+
+	mockRepo.On("GetById", "planning1").Return(planning.Planning{Id: "planning1", Players: []planning.Player{{Id: "player1"}}}, nil)
+	mockRepo.On("Leave", "planning1", "player1").Return(planning.Planning{Id: "planning1"}, nil)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	service.Connect("planning1", "player1")
+	service.Disconnect("planning1", "player1")
+
+	select {
+	case event := <-events.ch:
+		assert.Equal(t, planning.PlayerLeft{Planning: planning.Planning{Id: "planning1"}, PlayerId: "player1"}, event)
+	case <-time.After(time.Second):
+		t.Fatal("player was not removed after the grace period")
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_ReconnectCancelsLeave(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo, WithGracePeriod(20*time.Millisecond))
+	mockRepo.On("Touch", "planning1", mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("TouchPlayer", "planning1", "player1", mock.AnythingOfType("time.Time")).Return(nil)
+
+	service.Connect("planning1", "player1")
+	service.Disconnect("planning1", "player1")
+	service.Connect("planning1", "player1")
+
+	time.Sleep(50 * time.Millisecond)
+	mockRepo.AssertNotCalled(t, "Leave", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_DisconnectKeepsPlayerWithOtherConnection(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo, WithGracePeriod(10*time.Millisecond))
+	mockRepo.On("Touch", "planning1", mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("TouchPlayer", "planning1", "player1", mock.AnythingOfType("time.Time")).Return(nil)
+
+	service.Connect("planning1", "player1")
+	service.Connect("planning1", "player1")
+	service.Disconnect("planning1", "player1")
+
+	time.Sleep(30 * time.Millisecond)
+	mockRepo.AssertNotCalled(t, "Leave", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_DisconnectKeepsPlayerSeenOnOtherInstance(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo, WithGracePeriod(10*time.Millisecond))
+	mockRepo.On("Touch", "planning1", mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("TouchPlayer", "planning1", "player1", mock.AnythingOfType("time.Time")).Return(nil)
+	// Another instance marks the player as seen after the disconnect
+	seen := planning.Player{Id: "player1", LastSeen: time.Now().Add(time.Minute)}
+	mockRepo.On("GetById", "planning1").Return(planning.Planning{Id: "planning1", Players: []planning.Player{seen}}, nil)
+
+	service.Connect("planning1", "player1")
+	service.Disconnect("planning1", "player1")
+
+	time.Sleep(30 * time.Millisecond)
+	mockRepo.AssertNotCalled(t, "Leave", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_HeartbeatTouchesConnectedPlayers(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	mockRepo.On("Touch", "planning1", mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("TouchPlayer", "planning1", mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+
+	service.Connect("planning1", "player1")
+	service.Connect("planning1", "player2")
+	service.Disconnect("planning1", "player2")
+	service.heartbeat()
+
+	mockRepo.AssertNumberOfCalls(t, "TouchPlayer", 3) // two connects and the heartbeat of player1
+}
+
+func TestPlanningService_Resume(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo, WithTokenSecret([]byte("secret")))
+	mockRepo.On("Touch", "planning1", mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("TouchPlayer", "planning1", "player1", mock.AnythingOfType("time.Time")).Return(nil)
+
+	plan := planning.Planning{
+		Id:          "planning1",
+		Players:     []planning.Player{{Id: "player1", Name: "test-player"}},
+		HiddenVotes: map[string]string{"player1": "8"},
+	}
+	mockRepo.On("GetById", "planning1").Return(plan, nil)
+
+	p, player, err := service.Resume(service.ReconnectToken("planning1", "player1"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "8", p.MyVote)
+	assert.Equal(t, "test-player", player.Name)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_ResumeInvalidToken(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	_, _, err := service.Resume(signToken([]byte("other"), "planning1", "player1"))
+
+	assert.ErrorIs(t, err, planning.ErrInvalidToken)
+	mockRepo.AssertNotCalled(t, "GetById", mock.Anything)
+}
+
+func TestPlanningService_ResumeRemovedPlayer(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	mockRepo.On("TouchPlayer", "planning1", "player1", mock.AnythingOfType("time.Time")).Return(planning.ErrUnknownPlayer)
+	mockRepo.On("GetById", "planning1").Return(planning.Planning{Id: "planning1"}, nil)
+
+	_, _, err := service.Resume(service.ReconnectToken("planning1", "player1"))
+
+	assert.ErrorIs(t, err, planning.ErrUnknownPlayer)
+	assert.Empty(t, service.connections, "a failed resume must not count as connection")
+}
+
+func TestPlanningService_ConnectDoesNotWaitForOtherPlannings(t *testing.T) {
+	mockRepo := new(MockPlanningRepository)
+	service := NewPlanningService(mockRepo)
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	mockRepo.On("Touch", "slow", mock.AnythingOfType("time.Time")).Run(func(mock.Arguments) {
+		close(blocked)
+		<-release
+	}).Return(nil)
+	mockRepo.On("Touch", "fast", mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("TouchPlayer", mock.Anything, "player1", mock.AnythingOfType("time.Time")).Return(nil)
+
+	go service.Connect("slow", "player1")
+	<-blocked
+	connected := make(chan struct{})
+	go func() {
+		service.Connect("fast", "player1")
+		service.Disconnect("fast", "player1")
+		close(connected)
+	}()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("connecting waited for the repository write of another planning")
+	}
+	close(release)
+}
+
+func TestPlanningService_StartHeartbeat(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo, WithGracePeriod(30*time.Millisecond))
+	touched := make(chan struct{}, 100)
+	mockRepo.On("TouchPlayer", "planning1", "player1", mock.AnythingOfType("time.Time")).Run(func(mock.Arguments) {
+		touched <- struct{}{}
+	}).Return(nil)
+
+	service.Connect("planning1", "player1")
+	<-touched
+	stop := service.StartHeartbeat()
+
+	select {
+	case <-touched:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat did not mark the connected player as seen")
+	}
+	stop()
+	time.Sleep(30 * time.Millisecond) // a heartbeat running while stopping may still finish
+	for len(touched) > 0 {
+		<-touched
+	}
+	time.Sleep(30 * time.Millisecond)
+	assert.Empty(t, touched, "heartbeat kept running after it was stopped")
+}
+
+func TestPlanningService_DisconnectSchedulesOneLeave(t *testing.T) {
+	service := NewPlanningService(newMockRepository())
+	key := presenceKey{planningId: "planning1", playerId: "player1"}
+
+	service.presenceMu.Lock()
+	defer service.presenceMu.Unlock()
+	service.scheduleLeave(key)
+	first := service.pendingLeaves[key]
+	service.scheduleLeave(key)
+
+	assert.Len(t, service.pendingLeaves, 1)
+	assert.Same(t, first, service.pendingLeaves[key])
+	first.Stop()
+}
+
+func TestPlanningService_CancelledLeaveIsSkipped(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo, WithGracePeriod(time.Millisecond))
+	key := presenceKey{planningId: "planning1", playerId: "player1"}
+
+	// The leave is cancelled while its timer already fired and waits for the lock
+	service.presenceMu.Lock()
+	service.scheduleLeave(key)
+	time.Sleep(20 * time.Millisecond)
+	delete(service.pendingLeaves, key)
+	service.presenceMu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+	mockRepo.AssertNotCalled(t, "GetById", mock.Anything)
+	mockRepo.AssertNotCalled(t, "Leave", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_LeaveUnlessSeenPlanningGone(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	mockRepo.On("GetById", "planning1").Return(planning.Planning{}, errors.New("not found"))
+
+	service.leaveUnlessSeen("planning1", "player1", time.Now())
+
+	mockRepo.AssertNotCalled(t, "Leave", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_LeaveUnlessSeenPlayerGone(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	mockRepo.On("GetById", "planning1").Return(planning.Planning{Id: "planning1"}, nil)
+
+	service.leaveUnlessSeen("planning1", "player1", time.Now())
+
+	mockRepo.AssertNotCalled(t, "Leave", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_ResumePlanningGone(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	mockRepo.On("TouchPlayer", "planning1", "player1", mock.AnythingOfType("time.Time")).Return(planning.ErrUnknownPlayer)
+	mockRepo.On("GetById", "planning1").Return(planning.Planning{}, errors.New("not found"))
+
+	_, _, err := service.Resume(service.ReconnectToken("planning1", "player1"))
+
+	assert.Error(t, err)
+	assert.Empty(t, service.connections, "a failed resume must not count as connection")
+}
+
+func TestPlanningService_ConnectIgnoresTouchErr(t *testing.T) {
+	mockRepo := new(MockPlanningRepository)
+	service := NewPlanningService(mockRepo)
+	mockRepo.On("Touch", "planning1", mock.AnythingOfType("time.Time")).Return(errors.New("touch error"))
+	mockRepo.On("TouchPlayer", "planning1", "player1", mock.AnythingOfType("time.Time")).Return(nil)
+
+	service.Connect("planning1", "player1")
+
+	assert.Equal(t, 1, service.connections[presenceKey{planningId: "planning1", playerId: "player1"}])
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,42 @@
+package planningsvc
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"planning-poker/domain/planning"
+)
+
+// StartReaper expires plannings nobody connected to, disconnected from or changed for longer than ttl,
+// which includes tabs that were left open. Plannings are checked every interval and
+// PlanningClosed is published for every expired planning. The returned function stops the reaper.
+func (svc *PlanningService) StartReaper(ttl time.Duration, interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				svc.reap(ttl)
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+func (svc *PlanningService) reap(ttl time.Duration) {
+	expired, err := svc.planningRepository.DeleteIdle(time.Now().Add(-ttl))
+	if err != nil {
+		svc.logger.Error("Error deleting idle plannings", zap.Error(err))
+	}
+	for _, planningId := range expired {
+		svc.logger.Info("Planning expired", zap.String("planningId", planningId), zap.Duration("ttl", ttl))
+		svc.stopTimer(planningId)
+		svc.publish(planning.PlanningClosed{Id: planningId, Reason: planning.ClosedExpired})
+	}
+}
@@ -0,0 +1,83 @@
+package planningsvc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"planning-poker/domain/planning"
+)
+
+func TestPlanningService_ReapExpiresIdlePlannings(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	var idleSince time.Time
+	mockRepo.On("DeleteIdle", mock.AnythingOfType("time.Time")).Run(func(args mock.Arguments) {
+		idleSince = args.Get(0).(time.Time)
+	}).Return([]string{"planning1"}, nil)
+
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	service.reap(time.Hour)
+
+	assert.Equal(t, []planning.Event{planning.PlanningClosed{Id: "planning1", Reason: planning.ClosedExpired}}, events.drain())
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), idleSince, time.Second)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_StartReaper(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	mockRepo.On("DeleteIdle", mock.AnythingOfType("time.Time")).Return([]string{"planning1"}, nil)
+
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+	stop := service.StartReaper(time.Hour, 10*time.Millisecond)
+	defer stop()
+
+	select {
+	case event := <-events.ch:
+		assert.Equal(t, "planning1", event.PlanningId())
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not run")
+	}
+}
+
+func TestPlanningService_ChangesRecordActivity(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{Id: planningId, Deck: deck, Owner: planning.Player{Id: "owner"}, Players: []planning.Player{{Id: "owner"}}}
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("Vote", planningId, planning.Vote{PlayerId: "owner", CardId: "5"}).Return(nil)
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(planning.Planning{Id: planningId, Revealed: true}, true, nil)
+	mockRepo.On("ResetVotes", planningId).Return(nil)
+
+	_, err := service.Vote(planningId, "owner", "5")
+	assert.NoError(t, err)
+	_, err = service.RevealVotes(planningId, "owner")
+	assert.NoError(t, err)
+	assert.NoError(t, service.ResetVotes(planningId, "owner"))
+
+	mockRepo.AssertNumberOfCalls(t, "Touch", 3)
+}
+
+func TestPlanningService_ReapErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	mockRepo.On("DeleteIdle", mock.AnythingOfType("time.Time")).Return([]string(nil), errors.New("delete error"))
+
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	service.reap(time.Hour)
+
+	assert.Empty(t, events.drain())
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,31 @@
+package planningsvc
+
+import (
+	"go.uber.org/zap"
+)
+
+// Restore picks up the plannings the repository kept from before a restart. Timers that were
+// running are armed again, timers that ran out while the server was down run out right away.
+// The players lost their connections with the restart, so they get the grace period to resume.
+func (svc *PlanningService) Restore() error {
+	plans, err := svc.planningRepository.GetAll()
+	if err != nil {
+		svc.logger.Error("Error retrieving plannings to restore", zap.Error(err))
+		return err
+	}
+	for _, p := range plans {
+		if p.Timer != nil {
+			svc.armTimer(p.Id, p.Timer.Deadline)
+		}
+		svc.presenceMu.Lock()
+		for _, player := range p.Players {
+			key := presenceKey{planningId: p.Id, playerId: player.Id}
+			if svc.connections[key] == 0 {
+				svc.scheduleLeave(key)
+			}
+		}
+		svc.presenceMu.Unlock()
+	}
+	svc.logger.Info("Restored plannings", zap.Int("count", len(plans)))
+	return nil
+}
@@ -0,0 +1,82 @@
+package planningsvc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"planning-poker/domain/planning"
+)
+
+func TestPlanningService_RestoreArmsTimers(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	deadline := time.Now().Add(-time.Second) // ran out while the server was down
+	running := planning.Planning{Id: planningId, Timer: &planning.Timer{Deadline: deadline, AutoReveal: true}}
+
+	mockRepo.On("GetAll").Return([]planning.Planning{running, {Id: uuid.NewString()}}, nil)
+	mockRepo.On("GetById", planningId).Return(running, nil)
+	mockRepo.On("SetTimer", planningId, (*planning.Timer)(nil)).Return(planning.Planning{Id: planningId}, nil)
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(planning.Planning{Id: planningId, Revealed: true}, true, nil)
+
+	err := service.Restore()
+
+	assert.NoError(t, err)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events.ch:
+			if expired, ok := event.(planning.TimerExpired); ok {
+				assert.True(t, expired.Planning.Revealed)
+				return
+			}
+		case <-timeout:
+			t.Fatal("restored timer did not run out")
+		}
+	}
+}
+
+func TestPlanningService_RestoreWaitsForPlayersToResume(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo, WithGracePeriod(10*time.Millisecond))
+	events := newRecordingSubscriber()
+	service.Subscribe(events)
+
+	planningId := uuid.NewString()
+	restored := planning.Planning{Id: planningId, Players: []planning.Player{{Id: "player1"}, {Id: "player2"}}}
+
+	mockRepo.On("GetAll").Return([]planning.Planning{restored}, nil)
+	mockRepo.On("GetById", planningId).Return(restored, nil)
+	mockRepo.On("Touch", planningId, mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("TouchPlayer", planningId, "player1", mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("Leave", planningId, "player2").Return(planning.Planning{Id: planningId}, nil)
+
+	assert.NoError(t, service.Restore())
+	service.Connect(planningId, "player1")
+
+	select {
+	case event := <-events.ch:
+		assert.Equal(t, planning.PlayerLeft{Planning: planning.Planning{Id: planningId}, PlayerId: "player2"}, event)
+	case <-time.After(time.Second):
+		t.Fatal("restored player was not removed after the grace period")
+	}
+	mockRepo.AssertNotCalled(t, "Leave", planningId, "player1")
+}
+
+func TestPlanningService_RestoreErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+	mockRepo.On("GetAll").Return([]planning.Planning(nil), errors.New("read error"))
+
+	err := service.Restore()
+
+	assert.Error(t, err)
+	mockRepo.AssertExpectations(t)
+}
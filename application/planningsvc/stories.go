@@ -0,0 +1,99 @@
+package planningsvc
+
+import (
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"html"
+	"planning-poker/domain/planning"
+	"strings"
+)
+
+// AddStory adds a story to the backlog of a planning. The first story becomes the current one.
+func (svc *PlanningService) AddStory(planningId string, playerId string, story *planning.Story) (planning.Planning, error) {
+	svc.logger.Debug("Adding story to planning", zap.String("planningId", planningId), zap.String("title", story.Title))
+	story.Title = html.EscapeString(strings.TrimSpace(story.Title))
+	story.Description = html.EscapeString(story.Description)
+	story.ExternalKey = html.EscapeString(strings.TrimSpace(story.ExternalKey))
+	if story.Title == "" {
+		return planning.Planning{}, planning.ErrInvalidStory
+	}
+	if _, err := svc.authorizeModerator(planningId, playerId); err != nil {
+		return planning.Planning{}, err
+	}
+	story.Id = uuid.NewString()
+	story.Rounds = nil
+	p, err := svc.planningRepository.AddStory(planningId, *story)
+	if err != nil {
+		svc.logger.Error("Error adding story", zap.String("planningId", planningId), zap.Error(err))
+		return planning.Planning{}, err
+	}
+	svc.logger.Debug("Story added successfully", zap.String("planningId", planningId), zap.String("storyId", story.Id))
+	svc.touch(planningId)
+	svc.publish(planning.StoryAdded{Planning: svc.shared(p), Story: *story})
+	return p, nil
+}
+
+// NextStory moves the planning to the story after the current one and starts a new round
+func (svc *PlanningService) NextStory(planningId string, playerId string) (planning.Planning, error) {
+	svc.logger.Debug("Moving planning to next story", zap.String("planningId", planningId))
+	plan, err := svc.authorizeModerator(planningId, playerId)
+	if err != nil {
+		return planning.Planning{}, err
+	}
+	next := -1
+	for i, story := range plan.Stories {
+		if story.Id == plan.CurrentStoryId {
+			next = i + 1
+			break
+		}
+	}
+	if next < 0 || next >= len(plan.Stories) {
+		return planning.Planning{}, planning.ErrNoNextStory
+	}
+	p, err := svc.planningRepository.SetCurrentStory(planningId, plan.Stories[next].Id)
+	if err != nil {
+		svc.logger.Error("Error moving to next story", zap.String("planningId", planningId), zap.Error(err))
+		return planning.Planning{}, err
+	}
+	svc.stopTimer(planningId)
+	svc.logger.Debug("Moved to next story successfully", zap.String("planningId", planningId), zap.String("storyId", p.CurrentStoryId))
+	svc.touch(planningId)
+	svc.publish(planning.CurrentStoryChanged{Planning: svc.shared(p), StoryId: p.CurrentStoryId})
+	return p, nil
+}
+
+// SetEstimate records the estimate the team agreed on for the last round of the current story
+func (svc *PlanningService) SetEstimate(planningId string, playerId string, estimate string) (planning.Planning, error) {
+	svc.logger.Debug("Setting estimate of current story", zap.String("planningId", planningId), zap.String("estimate", estimate))
+	plan, err := svc.authorizeModerator(planningId, playerId)
+	if err != nil {
+		return planning.Planning{}, err
+	}
+	if _, ok := plan.Deck.Card(estimate); !ok {
+		return planning.Planning{}, planning.ErrInvalidVote
+	}
+	story, ok := plan.CurrentStory()
+	if !ok {
+		return planning.Planning{}, planning.ErrUnknownStory
+	}
+	p, err := svc.planningRepository.SetEstimate(planningId, story.Id, estimate)
+	if err != nil {
+		svc.logger.Error("Error setting estimate", zap.String("planningId", planningId), zap.Error(err))
+		return planning.Planning{}, err
+	}
+	svc.logger.Debug("Estimate set successfully", zap.String("planningId", planningId), zap.String("storyId", story.Id))
+	svc.touch(planningId)
+	svc.publish(planning.EstimateSet{Planning: svc.shared(p), StoryId: story.Id, Estimate: estimate})
+	return p, nil
+}
+
+// History returns all stories of a planning with their estimation rounds
+func (svc *PlanningService) History(planningId string) ([]planning.Story, error) {
+	svc.logger.Debug("Retrieving estimation history", zap.String("planningId", planningId))
+	p, err := svc.planningRepository.GetById(planningId)
+	if err != nil {
+		svc.logger.Error("Error retrieving planning for history", zap.String("planningId", planningId), zap.Error(err))
+		return nil, err
+	}
+	return p.Stories, nil
+}
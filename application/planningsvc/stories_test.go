@@ -0,0 +1,267 @@
+package planningsvc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"planning-poker/domain/planning"
+)
+
+func TestPlanningService_AddStory(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	story := &planning.Story{Title: " <b>Login</b> ", ExternalKey: "PROJ-1"}
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+	mockRepo.On("AddStory", planningId, mock.AnythingOfType("planning.Story")).Return(planning.Planning{Id: planningId}, nil)
+
+	_, err := service.AddStory(planningId, "owner", story)
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, story.Id)
+	assert.Equal(t, "&lt;b&gt;Login&lt;/b&gt;", story.Title)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_AddStoryWithoutTitle(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	_, err := service.AddStory(uuid.NewString(), "owner", &planning.Story{Title: "  "})
+
+	assert.ErrorIs(t, err, planning.ErrInvalidStory)
+	mockRepo.AssertNotCalled(t, "AddStory", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_AddStoryNotOwner(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+
+	_, err := service.AddStory(planningId, "player1", &planning.Story{Title: "Login"})
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "AddStory", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_NextStory(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	plan := planning.Planning{
+		Id:             planningId,
+		Owner:          planning.Player{Id: "owner"},
+		Stories:        []planning.Story{{Id: "story1"}, {Id: "story2"}},
+		CurrentStoryId: "story1",
+	}
+
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("SetCurrentStory", planningId, "story2").Return(planning.Planning{Id: planningId, CurrentStoryId: "story2"}, nil)
+
+	p, err := service.NextStory(planningId, "owner")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "story2", p.CurrentStoryId)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_NextStoryAtEnd(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	plan := planning.Planning{
+		Id:             planningId,
+		Owner:          planning.Player{Id: "owner"},
+		Stories:        []planning.Story{{Id: "story1"}},
+		CurrentStoryId: "story1",
+	}
+
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+
+	_, err := service.NextStory(planningId, "owner")
+
+	assert.ErrorIs(t, err, planning.ErrNoNextStory)
+	mockRepo.AssertNotCalled(t, "SetCurrentStory", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_SetEstimate(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{
+		Id:             planningId,
+		Owner:          planning.Player{Id: "owner"},
+		Deck:           deck,
+		Stories:        []planning.Story{{Id: "story1"}},
+		CurrentStoryId: "story1",
+	}
+
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("SetEstimate", planningId, "story1", "8").Return(plan, nil)
+
+	_, err := service.SetEstimate(planningId, "owner", "8")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_SetEstimateNotInDeck(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{
+		Id:             planningId,
+		Owner:          planning.Player{Id: "owner"},
+		Deck:           deck,
+		Stories:        []planning.Story{{Id: "story1"}},
+		CurrentStoryId: "story1",
+	}
+
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+
+	_, err := service.SetEstimate(planningId, "owner", "XL")
+
+	assert.ErrorIs(t, err, planning.ErrInvalidVote)
+	mockRepo.AssertNotCalled(t, "SetEstimate", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_SetEstimateWithoutStory(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}, Deck: deck}, nil)
+
+	_, err := service.SetEstimate(planningId, "owner", "8")
+
+	assert.ErrorIs(t, err, planning.ErrUnknownStory)
+}
+
+func TestPlanningService_History(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	stories := []planning.Story{{Id: "story1", Rounds: []planning.Round{{Estimate: "5"}}}}
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Stories: stories}, nil)
+
+	history, err := service.History(planningId)
+
+	assert.NoError(t, err)
+	assert.Equal(t, stories, history)
+}
+
+func TestPlanningService_HistoryNotFound(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	mockRepo.On("GetById", "missing").Return(planning.Planning{}, planning.ErrPlanningNotFound)
+
+	_, err := service.History("missing")
+
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+}
+
+func TestPlanningService_AddStoryErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+	mockRepo.On("AddStory", planningId, mock.AnythingOfType("planning.Story")).Return(planning.Planning{}, errors.New("story error"))
+
+	_, err := service.AddStory(planningId, "owner", &planning.Story{Title: "Login"})
+
+	assert.Error(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_NextStoryNotOwner(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+
+	_, err := service.NextStory(planningId, "player1")
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "SetCurrentStory", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_NextStoryErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	plan := planning.Planning{
+		Id:             planningId,
+		Owner:          planning.Player{Id: "owner"},
+		Stories:        []planning.Story{{Id: "story1"}, {Id: "story2"}},
+		CurrentStoryId: "story1",
+	}
+
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("SetCurrentStory", planningId, "story2").Return(planning.Planning{}, errors.New("story error"))
+
+	_, err := service.NextStory(planningId, "owner")
+
+	assert.Error(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_SetEstimateNotOwner(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+
+	_, err := service.SetEstimate(planningId, "player1", "8")
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "SetEstimate", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_SetEstimateErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	plan := planning.Planning{
+		Id:             planningId,
+		Owner:          planning.Player{Id: "owner"},
+		Deck:           deck,
+		Stories:        []planning.Story{{Id: "story1"}},
+		CurrentStoryId: "story1",
+	}
+
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("SetEstimate", planningId, "story1", "8").Return(planning.Planning{}, errors.New("estimate error"))
+
+	_, err := service.SetEstimate(planningId, "owner", "8")
+
+	assert.Error(t, err)
+	mockRepo.AssertExpectations(t)
+}
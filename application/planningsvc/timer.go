@@ -0,0 +1,112 @@
+package planningsvc
+
+import (
+	"go.uber.org/zap"
+	"planning-poker/domain/planning"
+	"time"
+)
+
+const (
+	minTimerDuration = time.Second
+	maxTimerDuration = time.Hour
+)
+
+// TimerDuration turns the seconds a client asked for into the duration of a timer. The range is
+// checked before converting, so large values can't overflow into a valid duration.
+func TimerDuration(seconds int) (time.Duration, error) {
+	if seconds < int(minTimerDuration/time.Second) || seconds > int(maxTimerDuration/time.Second) {
+		return 0, planning.ErrInvalidTimer
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// StartTimer starts a countdown for the current round. A running timer is replaced.
+// TimerExpired is published when the timer runs out.
+func (svc *PlanningService) StartTimer(planningId string, playerId string, duration time.Duration, autoReveal bool) (planning.Planning, error) {
+	svc.logger.Debug("Starting timer", zap.String("planningId", planningId), zap.Duration("duration", duration))
+	if duration < minTimerDuration || duration > maxTimerDuration {
+		return planning.Planning{}, planning.ErrInvalidTimer
+	}
+	if _, err := svc.authorizeModerator(planningId, playerId); err != nil {
+		return planning.Planning{}, err
+	}
+	deadline := time.Now().Add(duration)
+	p, err := svc.planningRepository.SetTimer(planningId, &planning.Timer{Deadline: deadline, AutoReveal: autoReveal})
+	if err != nil {
+		svc.logger.Error("Error starting timer", zap.String("planningId", planningId), zap.Error(err))
+		return planning.Planning{}, err
+	}
+	svc.armTimer(planningId, deadline)
+	svc.logger.Debug("Timer started successfully", zap.String("planningId", planningId), zap.Time("deadline", deadline))
+	p = svc.shared(p)
+	svc.touch(planningId)
+	svc.publish(planning.TimerStarted{Planning: p})
+	return p, nil
+}
+
+// armTimer lets the timer of a planning run out at the deadline, replacing a running one.
+// Deadlines in the past run out right away.
+func (svc *PlanningService) armTimer(planningId string, deadline time.Time) {
+	svc.timersMu.Lock()
+	defer svc.timersMu.Unlock()
+	if running, ok := svc.timers[planningId]; ok {
+		running.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(deadline), func() {
+		svc.timersMu.Lock()
+		if svc.timers[planningId] != timer {
+			svc.timersMu.Unlock()
+			return
+		}
+		delete(svc.timers, planningId)
+		svc.timersMu.Unlock()
+		if p, err := svc.expireTimer(planningId, deadline); err == nil {
+			svc.publish(planning.TimerExpired{Planning: p})
+		}
+	})
+	svc.timers[planningId] = timer
+}
+
+func (svc *PlanningService) stopTimer(planningId string) {
+	svc.timersMu.Lock()
+	defer svc.timersMu.Unlock()
+	if timer, ok := svc.timers[planningId]; ok {
+		timer.Stop()
+		delete(svc.timers, planningId)
+	}
+}
+
+// expireTimer clears the timer of a planning and reveals the votes if the timer asked for it
+func (svc *PlanningService) expireTimer(planningId string, deadline time.Time) (planning.Planning, error) {
+	p, err := svc.planningRepository.GetById(planningId)
+	if err != nil {
+		svc.logger.Debug("Planning of expired timer is gone", zap.String("planningId", planningId))
+		return planning.Planning{}, err
+	}
+	if p.Timer == nil || !p.Timer.Deadline.Equal(deadline) {
+		return planning.Planning{}, planning.ErrInvalidTimer
+	}
+	autoReveal := p.Timer.AutoReveal
+	p, err = svc.planningRepository.SetTimer(planningId, nil)
+	if err != nil {
+		svc.logger.Error("Error clearing expired timer", zap.String("planningId", planningId), zap.Error(err))
+		return planning.Planning{}, err
+	}
+	svc.logger.Debug("Timer expired", zap.String("planningId", planningId))
+	if autoReveal && !p.Revealed {
+		return svc.reveal(planningId)
+	}
+	return svc.shared(p), nil
+}
+
+// withTimerRemaining fills in how much time is left, so late joiners see the right countdown
+func (svc *PlanningService) withTimerRemaining(p planning.Planning) planning.Planning {
+	if p.Timer == nil {
+		return p
+	}
+	timer := *p.Timer
+	timer.RemainingSeconds = max(time.Until(timer.Deadline).Seconds(), 0)
+	p.Timer = &timer
+	return p
+}
@@ -0,0 +1,265 @@
+package planningsvc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"planning-poker/domain/planning"
+)
+
+func TestPlanningService_StartTimer(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	owner := planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}
+	var timer *planning.Timer
+
+	mockRepo.On("GetById", planningId).Return(owner, nil).Once()
+	mockRepo.On("SetTimer", planningId, mock.AnythingOfType("*planning.Timer")).Run(func(args mock.Arguments) {
+		timer = args.Get(1).(*planning.Timer)
+	}).Return(planning.Planning{Id: planningId, Timer: &planning.Timer{Deadline: time.Now().Add(time.Second)}}, nil).Once()
+
+	p, err := service.StartTimer(planningId, "owner", time.Second, false)
+
+	assert.NoError(t, err)
+	assert.InDelta(t, 1, p.Timer.RemainingSeconds, 0.1)
+	assert.WithinDuration(t, time.Now().Add(time.Second), timer.Deadline, 100*time.Millisecond)
+	service.stopTimer(planningId)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_StartTimerInvalidDuration(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	_, err := service.StartTimer(uuid.NewString(), "owner", 0, false)
+	assert.ErrorIs(t, err, planning.ErrInvalidTimer)
+
+	_, err = service.StartTimer(uuid.NewString(), "owner", 2*time.Hour, false)
+	assert.ErrorIs(t, err, planning.ErrInvalidTimer)
+	mockRepo.AssertNotCalled(t, "SetTimer", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_StartTimerNotOwner(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+
+	_, err := service.StartTimer(planningId, "player1", time.Minute, false)
+
+	assert.ErrorIs(t, err, planning.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "SetTimer", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_ExpireTimerReveals(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deadline := time.Now()
+	running := planning.Planning{Id: planningId, Timer: &planning.Timer{Deadline: deadline, AutoReveal: true}}
+
+	mockRepo.On("GetById", planningId).Return(running, nil)
+	mockRepo.On("SetTimer", planningId, (*planning.Timer)(nil)).Return(planning.Planning{Id: planningId}, nil)
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(planning.Planning{Id: planningId, Revealed: true}, true, nil)
+
+	p, err := service.expireTimer(planningId, deadline)
+
+	assert.NoError(t, err)
+	assert.True(t, p.Revealed)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_ExpireTimerWithoutReveal(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deadline := time.Now()
+	running := planning.Planning{Id: planningId, Timer: &planning.Timer{Deadline: deadline}}
+
+	mockRepo.On("GetById", planningId).Return(running, nil)
+	mockRepo.On("SetTimer", planningId, (*planning.Timer)(nil)).Return(planning.Planning{Id: planningId}, nil)
+
+	p, err := service.expireTimer(planningId, deadline)
+
+	assert.NoError(t, err)
+	assert.False(t, p.Revealed)
+	mockRepo.AssertNotCalled(t, "RevealVotes", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_ExpireReplacedTimer(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	running := planning.Planning{Id: planningId, Timer: &planning.Timer{Deadline: time.Now().Add(time.Minute)}}
+
+	mockRepo.On("GetById", planningId).Return(running, nil)
+
+	_, err := service.expireTimer(planningId, time.Now())
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "SetTimer", mock.Anything, mock.Anything)
+}
+
+// hasTimer reports whether the service runs a timer for the planning
+func hasTimer(service *PlanningService, planningId string) bool {
+	service.timersMu.Lock()
+	defer service.timersMu.Unlock()
+	_, ok := service.timers[planningId]
+	return ok
+}
+
+func TestPlanningService_ResetStopsTimer(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	owner := planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}
+	service.armTimer(planningId, time.Now().Add(time.Minute))
+
+	mockRepo.On("GetById", planningId).Return(owner, nil)
+	mockRepo.On("ResetVotes", planningId).Return(nil)
+
+	err := service.ResetVotes(planningId, "owner")
+
+	assert.NoError(t, err)
+	assert.False(t, hasTimer(service, planningId))
+}
+
+func TestPlanningService_RevealStopsTimer(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	owner := planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}
+	service.armTimer(planningId, time.Now().Add(time.Minute))
+
+	mockRepo.On("GetById", planningId).Return(owner, nil)
+	mockRepo.On("RevealVotes", planningId, mock.AnythingOfType("time.Time")).Return(planning.Planning{Id: planningId, Revealed: true}, true, nil)
+
+	_, err := service.RevealVotes(planningId, "owner")
+
+	assert.NoError(t, err)
+	assert.False(t, hasTimer(service, planningId))
+}
+
+func TestPlanningService_NextStoryStopsTimer(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	plan := planning.Planning{
+		Id:             planningId,
+		Owner:          planning.Player{Id: "owner"},
+		Stories:        []planning.Story{{Id: "story1"}, {Id: "story2"}},
+		CurrentStoryId: "story1",
+	}
+	service.armTimer(planningId, time.Now().Add(time.Minute))
+
+	mockRepo.On("GetById", planningId).Return(plan, nil)
+	mockRepo.On("SetCurrentStory", planningId, "story2").Return(planning.Planning{Id: planningId, CurrentStoryId: "story2"}, nil)
+
+	_, err := service.NextStory(planningId, "owner")
+
+	assert.NoError(t, err)
+	assert.False(t, hasTimer(service, planningId))
+}
+
+func TestPlanningService_StartTimerErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Owner: planning.Player{Id: "owner"}}, nil)
+	mockRepo.On("SetTimer", planningId, mock.AnythingOfType("*planning.Timer")).Return(planning.Planning{}, errors.New("timer error"))
+
+	_, err := service.StartTimer(planningId, "owner", time.Minute, false)
+
+	assert.Error(t, err)
+	assert.False(t, hasTimer(service, planningId))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlanningService_ArmTimerReplacesRunningTimer(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	service.armTimer(planningId, time.Now().Add(20*time.Millisecond))
+	service.armTimer(planningId, time.Now().Add(time.Minute))
+
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, hasTimer(service, planningId))
+	mockRepo.AssertNotCalled(t, "GetById", mock.Anything)
+	service.stopTimer(planningId)
+}
+
+func TestPlanningService_StoppedTimerDoesNotExpire(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	// The timer is stopped while it already ran out and waits for the lock
+	service.armTimer(planningId, time.Now().Add(time.Millisecond))
+	service.timersMu.Lock()
+	time.Sleep(20 * time.Millisecond)
+	delete(service.timers, planningId)
+	service.timersMu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+	mockRepo.AssertNotCalled(t, "GetById", mock.Anything)
+}
+
+func TestPlanningService_ExpireTimerPlanningGone(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{}, errors.New("not found"))
+
+	_, err := service.expireTimer(planningId, time.Now())
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "SetTimer", mock.Anything, mock.Anything)
+}
+
+func TestPlanningService_ExpireTimerErr(t *testing.T) {
+	mockRepo := newMockRepository()
+	service := NewPlanningService(mockRepo)
+
+	planningId := uuid.NewString()
+	deadline := time.Now()
+
+	mockRepo.On("GetById", planningId).Return(planning.Planning{Id: planningId, Timer: &planning.Timer{Deadline: deadline, AutoReveal: true}}, nil)
+	mockRepo.On("SetTimer", planningId, (*planning.Timer)(nil)).Return(planning.Planning{}, errors.New("timer error"))
+
+	_, err := service.expireTimer(planningId, deadline)
+
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "RevealVotes", mock.Anything, mock.Anything)
+}
+
+func TestTimerDuration(t *testing.T) {
+	d, err := TimerDuration(90)
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+
+	for _, seconds := range []int{0, 3601, -1, 18446744075} {
+		_, err := TimerDuration(seconds)
+		assert.ErrorIs(t, err, planning.ErrInvalidTimer, seconds)
+	}
+}
@@ -0,0 +1,52 @@
+package planningsvc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"planning-poker/domain/planning"
+)
+
+// signToken creates a reconnect token binding a player to a planning.
+// Format: base64(planningId).base64(playerId).base64(hmac)
+func signToken(secret []byte, planningId string, playerId string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(planningId)) + "." +
+		enc.EncodeToString([]byte(playerId)) + "." +
+		enc.EncodeToString(tokenMac(secret, planningId, playerId))
+}
+
+// verifyToken checks the signature of a reconnect token and returns the planning and player ID
+func verifyToken(secret []byte, token string) (string, string, error) {
+	enc := base64.RawURLEncoding
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "", planning.ErrInvalidToken
+	}
+	planningId, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return "", "", planning.ErrInvalidToken
+	}
+	playerId, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return "", "", planning.ErrInvalidToken
+	}
+	mac, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return "", "", planning.ErrInvalidToken
+	}
+	if !hmac.Equal(mac, tokenMac(secret, string(planningId), string(playerId))) {
+		return "", "", planning.ErrInvalidToken
+	}
+	return string(planningId), string(playerId), nil
+}
+
+func tokenMac(secret []byte, planningId string, playerId string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(planningId))
+	h.Write([]byte{0})
+	h.Write([]byte(playerId))
+	return h.Sum(nil)
+}
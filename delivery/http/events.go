@@ -0,0 +1,97 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// keepAliveInterval is how often an idle event stream gets a comment, so proxies don't cut it off
+	keepAliveInterval = 30 * time.Second
+	// streamQueueSize is how many messages are buffered per event stream, like the send queue of a websocket connection
+	streamQueueSize = 64
+)
+
+// eventStream is a client of the event stream of a planning, subscribed to the hub like a websocket connection
+type eventStream struct {
+	send      chan []byte
+	done      chan struct{} // done is closed once the stream can't keep up
+	closeOnce sync.Once
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		send: make(chan []byte, streamQueueSize),
+		done: make(chan struct{}),
+	}
+}
+
+// Send queues a message for the stream. A stream whose queue is full can't keep up and is ended,
+// EventSource then reconnects on its own.
+func (s *eventStream) Send(msg []byte) {
+	select {
+	case <-s.done:
+	case s.send <- msg:
+	default:
+		s.closeOnce.Do(func() { close(s.done) })
+	}
+}
+
+// handleEvents streams the messages the websocket clients of the planning receive as server-sent
+// events, for clients behind proxies that don't let websockets through. Every event carries one
+// message as JSON in its data. EventSource can't send an authorization header, so a player passes
+// the token as query parameter instead and counts as connected while the stream is open.
+func (h *APIHandler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	if _, err := h.planningSvc.GetById(planningId, ""); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		playerId, err := h.authenticateToken(token, planningId)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		h.planningSvc.Connect(planningId, playerId)
+		defer h.planningSvc.Disconnect(planningId, playerId)
+	}
+
+	stream := newEventStream()
+	h.hub.Subscribe(planningId, stream)
+	defer h.hub.Unsubscribe(planningId, stream)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keeps nginx from holding back the events
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	// The comment flushes the headers, the client knows it is subscribed once it reads them
+	_, err := io.WriteString(w, ": subscribed\n\n")
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for err == nil {
+		if err = rc.Flush(); err != nil {
+			break
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stream.done:
+			h.logger.Warn("event stream can't keep up, ending it", zap.String("planningId", planningId))
+			return
+		case <-ticker.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case msg := <-stream.send:
+			// Marshaled JSON has no line breaks, so the message fits in a single data line
+			_, err = fmt.Fprintf(w, "data: %s\n\n", msg)
+		}
+	}
+	h.logger.Info("event stream closed", zap.String("planningId", planningId), zap.Error(err))
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	wsdelivery "planning-poker/delivery/websocket"
+	"planning-poker/domain/planning"
+)
+
+// openEvents opens the event stream of a planning and returns a function reading the next message
+func openEvents(t *testing.T, srv *httptest.Server, planningId string) func() wsdelivery.Message {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/plannings/"+planningId+"/events", nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = res.Body.Close() })
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				lines <- data
+			}
+		}
+		close(lines)
+	}()
+	return func() wsdelivery.Message {
+		select {
+		case data, ok := <-lines:
+			require.True(t, ok, "event stream ended")
+			var msg wsdelivery.Message
+			require.NoError(t, json.Unmarshal([]byte(data), &msg))
+			return msg
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "no event received")
+			return wsdelivery.Message{}
+		}
+	}
+}
+
+func TestAPIHandler_EventsFollowTheRESTCommands(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+	readEvent := openEvents(t, srv, created.PlanningId)
+
+	guest := joinPlanning(t, srv, created.PlanningId, "guest")
+	assert.Equal(t, "join", readEvent().Type)
+	call(t, http.MethodPost, srv.URL+"/api/plannings/"+created.PlanningId+"/votes", guest.Token, map[string]string{"value": "5"}, nil)
+	assert.Equal(t, "vote", readEvent().Type)
+	call(t, http.MethodPost, srv.URL+"/api/plannings/"+created.PlanningId+"/reveal", created.Token, nil, nil)
+
+	event := readEvent()
+	assert.Equal(t, "reveal", event.Type)
+	var p planning.Planning
+	require.NoError(t, json.Unmarshal(event.Payload, &p))
+	assert.Equal(t, "5", p.Votes[guest.PlayerId])
+}
+
+func TestAPIHandler_EventsShareTheHubWithWebsocketClients(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+	readEvent := openEvents(t, srv, created.PlanningId)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?version=1", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "join", "payload": map[string]interface{}{"planningId": created.PlanningId, "player": map[string]string{"name": "guest"}}}))
+	assert.Equal(t, "join", readEvent().Type)
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "vote", "payload": map[string]string{"value": "3"}}))
+	assert.Equal(t, "vote", readEvent().Type)
+	assert.Equal(t, http.StatusNoContent, call(t, http.MethodDelete, srv.URL+"/api/plannings/"+created.PlanningId, created.Token, nil, nil))
+	assert.Equal(t, "close", readEvent().Type)
+}
+
+func TestAPIHandler_EventsOfUnknownPlanning(t *testing.T) {
+	srv := newTestServer(t)
+
+	status := call(t, http.MethodGet, srv.URL+"/api/plannings/unknown/events", "", nil, nil)
+
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestAPIHandler_EventsWithForgedToken(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+
+	status := call(t, http.MethodGet, srv.URL+"/api/plannings/"+created.PlanningId+"/events?token=forged", "", nil, nil)
+
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
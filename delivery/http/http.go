@@ -0,0 +1,393 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"planning-poker/application/planningsvc"
+	wsdelivery "planning-poker/delivery/websocket"
+	"planning-poker/domain/planning"
+	"planning-poker/infra"
+)
+
+// maxBodySize limits request bodies, like the read limit of the websocket connections
+const maxBodySize = 64 * 1024
+
+var (
+	errBadRequest    = &planning.Error{Code: "bad_request", Message: "request body is invalid"}
+	errUnauthorized  = &planning.Error{Code: "unauthorized", Message: "request needs the token of a player as bearer token"}
+	errWrongPlanning = &planning.Error{Code: "wrong_planning", Message: "token was issued for a different planning"}
+	errInternal      = &planning.Error{Code: "internal_error", Message: "something went wrong"}
+)
+
+// APIHandler exposes the planning service as a REST API. Players authenticate with the token
+// they get when creating or joining a planning, sent as bearer token. Changes reach the
+// websocket clients through the events of the planning service, and the clients of the event
+// stream through the hub they share with the websocket clients.
+type APIHandler struct {
+	planningSvc *planningsvc.PlanningService
+	hub         *wsdelivery.Hub
+	logger      *zap.Logger
+	mux         *http.ServeMux
+}
+
+func NewAPIHandler(planningSvc *planningsvc.PlanningService, hub *wsdelivery.Hub) *APIHandler {
+	h := &APIHandler{
+		planningSvc: planningSvc,
+		hub:         hub,
+		logger:      infra.GetLogger(),
+		mux:         http.NewServeMux(),
+	}
+	h.mux.HandleFunc("POST /api/plannings", h.handleCreate)
+	h.mux.HandleFunc("GET /api/plannings/{id}", h.handleGet)
+	h.mux.HandleFunc("DELETE /api/plannings/{id}", h.handleClose)
+	h.mux.HandleFunc("GET /api/plannings/{id}/events", h.handleEvents)
+	h.mux.HandleFunc("POST /api/plannings/{id}/players", h.handleJoin)
+	h.mux.HandleFunc("POST /api/plannings/{id}/votes", h.handleVote)
+	h.mux.HandleFunc("POST /api/plannings/{id}/reveal", h.handleReveal)
+	h.mux.HandleFunc("POST /api/plannings/{id}/reset", h.handleReset)
+	h.mux.HandleFunc("POST /api/plannings/{id}/players/{playerId}/role", h.handleSetRole)
+	h.mux.HandleFunc("POST /api/plannings/{id}/stories", h.handleAddStory)
+	h.mux.HandleFunc("POST /api/plannings/{id}/next-story", h.handleNextStory)
+	h.mux.HandleFunc("POST /api/plannings/{id}/estimate", h.handleSetEstimate)
+	h.mux.HandleFunc("POST /api/plannings/{id}/timer", h.handleStartTimer)
+	h.mux.HandleFunc("GET /api/plannings/{id}/history", h.handleHistory)
+	return h
+}
+
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// joinedResponse tells a new player who they are and which token to send, like the joined websocket reply
+type joinedResponse struct {
+	PlanningId string            `json:"planningId"`
+	PlayerId   string            `json:"playerId"`
+	Token      string            `json:"token"`
+	Planning   planning.Planning `json:"planning"`
+}
+
+func (h *APIHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req wsdelivery.CreateRequest
+	if err := h.decode(w, r, &req); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	p := req.Planning()
+	if err := h.planningSvc.Create(&p); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJoined(w, p.Id, p.Owner.Id)
+}
+
+// handleGet returns the planning. With a token the planning includes the vote of its player.
+func (h *APIHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId := ""
+	if r.Header.Get("Authorization") != "" {
+		var err error
+		if playerId, err = h.authenticate(r, planningId); err != nil {
+			h.writeError(w, err)
+			return
+		}
+	}
+	h.writePlanning(w, planningId, playerId)
+}
+
+func (h *APIHandler) handleClose(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId, err := h.authenticate(r, planningId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if err := h.planningSvc.Close(planningId, playerId); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleJoin adds a player that doesn't count as connected, so they aren't removed after the
+// grace period like websocket players: scripts and bots act without keeping a connection open.
+// They count as connected while they follow the event stream with their token.
+func (h *APIHandler) handleJoin(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	var req wsdelivery.NewPlayer
+	if err := h.decode(w, r, &req); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	player := req.Player()
+	if _, err := h.planningSvc.Join(planningId, &player); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJoined(w, planningId, player.Id)
+}
+
+func (h *APIHandler) handleVote(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId, err := h.authenticate(r, planningId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	var req struct {
+		Value string `json:"value"`
+	}
+	if err := h.decode(w, r, &req); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if _, err := h.planningSvc.Vote(planningId, playerId, req.Value); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePlanning(w, planningId, playerId)
+}
+
+func (h *APIHandler) handleReveal(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId, err := h.authenticate(r, planningId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if _, err := h.planningSvc.RevealVotes(planningId, playerId); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePlanning(w, planningId, playerId)
+}
+
+func (h *APIHandler) handleReset(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId, err := h.authenticate(r, planningId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if err := h.planningSvc.ResetVotes(planningId, playerId); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePlanning(w, planningId, playerId)
+}
+
+// handleSetRole changes the role of the player in the path, only the owner may change roles
+func (h *APIHandler) handleSetRole(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId, err := h.authenticate(r, planningId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	var req struct {
+		Role planning.Role `json:"role"`
+	}
+	if err := h.decode(w, r, &req); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if _, err := h.planningSvc.SetRole(planningId, playerId, r.PathValue("playerId"), req.Role); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePlanning(w, planningId, playerId)
+}
+
+func (h *APIHandler) handleAddStory(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId, err := h.authenticate(r, planningId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	var req wsdelivery.NewStory
+	if err := h.decode(w, r, &req); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	story := req.Story()
+	if _, err := h.planningSvc.AddStory(planningId, playerId, &story); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePlanning(w, planningId, playerId)
+}
+
+func (h *APIHandler) handleNextStory(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId, err := h.authenticate(r, planningId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if _, err := h.planningSvc.NextStory(planningId, playerId); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePlanning(w, planningId, playerId)
+}
+
+func (h *APIHandler) handleSetEstimate(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId, err := h.authenticate(r, planningId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	var req struct {
+		Estimate string `json:"estimate"`
+	}
+	if err := h.decode(w, r, &req); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if _, err := h.planningSvc.SetEstimate(planningId, playerId, req.Estimate); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePlanning(w, planningId, playerId)
+}
+
+func (h *APIHandler) handleStartTimer(w http.ResponseWriter, r *http.Request) {
+	planningId := r.PathValue("id")
+	playerId, err := h.authenticate(r, planningId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	var req struct {
+		Seconds    int  `json:"seconds"`
+		AutoReveal bool `json:"autoReveal"`
+	}
+	if err := h.decode(w, r, &req); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	duration, err := planningsvc.TimerDuration(req.Seconds)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if _, err := h.planningSvc.StartTimer(planningId, playerId, duration, req.AutoReveal); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePlanning(w, planningId, playerId)
+}
+
+// handleHistory returns the stories with their estimation rounds, like reading the planning it needs no token
+func (h *APIHandler) handleHistory(w http.ResponseWriter, r *http.Request) {
+	stories, err := h.planningSvc.History(r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, stories)
+}
+
+// authenticate returns the player of the bearer token, which has to be issued for the planning
+func (h *APIHandler) authenticate(r *http.Request, planningId string) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", errUnauthorized
+	}
+	return h.authenticateToken(token, planningId)
+}
+
+func (h *APIHandler) authenticateToken(token string, planningId string) (string, error) {
+	tokenPlanningId, playerId, err := h.planningSvc.Authenticate(token)
+	if err != nil {
+		return "", err
+	}
+	if tokenPlanningId != planningId {
+		return "", errWrongPlanning
+	}
+	return playerId, nil
+}
+
+func (h *APIHandler) decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return &planning.Error{Code: errBadRequest.Code, Message: err.Error()}
+	}
+	return nil
+}
+
+func (h *APIHandler) writeJoined(w http.ResponseWriter, planningId string, playerId string) {
+	p, err := h.planningSvc.GetById(planningId, playerId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, joinedResponse{
+		PlanningId: planningId,
+		PlayerId:   playerId,
+		Token:      h.planningSvc.ReconnectToken(planningId, playerId),
+		Planning:   p,
+	})
+}
+
+// writePlanning responds with the planning as the player sees it
+func (h *APIHandler) writePlanning(w http.ResponseWriter, planningId string, playerId string) {
+	p, err := h.planningSvc.GetById(planningId, playerId)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, p)
+}
+
+// writeError responds with the domain error and its status code. Other errors are logged and
+// not passed on to the client.
+func (h *APIHandler) writeError(w http.ResponseWriter, err error) {
+	status := statusCode(err)
+	var domainErr *planning.Error
+	if status == http.StatusInternalServerError || !errors.As(err, &domainErr) {
+		h.logger.Error("failed to handle api request", zap.Error(err))
+		domainErr = errInternal
+	}
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	h.writeJSON(w, status, domainErr)
+}
+
+// statusCode maps domain errors to HTTP status codes
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, errBadRequest),
+		errors.Is(err, planning.ErrInvalidVote),
+		errors.Is(err, planning.ErrInvalidDeck),
+		errors.Is(err, planning.ErrInvalidRole),
+		errors.Is(err, planning.ErrInvalidStory),
+		errors.Is(err, planning.ErrInvalidTimer):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnauthorized), errors.Is(err, planning.ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, errWrongPlanning), errors.Is(err, planning.ErrForbidden), errors.Is(err, planning.ErrCannotVote):
+		return http.StatusForbidden
+	case errors.Is(err, planning.ErrPlanningNotFound), errors.Is(err, planning.ErrUnknownPlayer), errors.Is(err, planning.ErrUnknownStory):
+		return http.StatusNotFound
+	case errors.Is(err, planning.ErrPlanningExists), errors.Is(err, planning.ErrNoNextStory), errors.Is(err, planning.ErrNoRound),
+		errors.Is(err, planning.ErrRoundRevealed):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *APIHandler) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("failed to write api response", zap.Error(err))
+	}
+}
@@ -0,0 +1,369 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"planning-poker/application/planningsvc"
+	wsdelivery "planning-poker/delivery/websocket"
+	"planning-poker/domain/planning"
+	"planning-poker/infra/in_memory"
+)
+
+// newTestServer serves the REST API next to the websocket endpoint, both on the same service and hub
+func newTestServer(t *testing.T, opts ...planningsvc.Option) *httptest.Server {
+	svc := planningsvc.NewPlanningService(in_memory.NewPlanningRepository(), opts...)
+	hub := wsdelivery.NewHub(svc, wsdelivery.NewLocalBroadcaster())
+	mux := http.NewServeMux()
+	mux.Handle("/api/", NewAPIHandler(svc, hub))
+	mux.Handle("/ws", wsdelivery.NewWebsocketHandler(svc, wsdelivery.WithHub(hub)))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// call sends a request with an optional bearer token and JSON body and decodes the JSON response into out
+func call(t *testing.T, method string, url string, token string, body interface{}, out interface{}) int {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	if out != nil {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(out))
+	}
+	return res.StatusCode
+}
+
+func createPlanning(t *testing.T, srv *httptest.Server) joinedResponse {
+	var created joinedResponse
+	status := call(t, http.MethodPost, srv.URL+"/api/plannings", "", map[string]interface{}{"owner": map[string]string{"name": "owner"}}, &created)
+	require.Equal(t, http.StatusCreated, status)
+	return created
+}
+
+func joinPlanning(t *testing.T, srv *httptest.Server, planningId string, name string) joinedResponse {
+	var joined joinedResponse
+	status := call(t, http.MethodPost, srv.URL+"/api/plannings/"+planningId+"/players", "", map[string]string{"name": name}, &joined)
+	require.Equal(t, http.StatusCreated, status)
+	return joined
+}
+
+func TestAPIHandler_CreateAndGet(t *testing.T) {
+	srv := newTestServer(t)
+
+	created := createPlanning(t, srv)
+	assert.NotEmpty(t, created.PlanningId)
+	assert.NotEmpty(t, created.Token)
+	assert.Equal(t, created.PlayerId, created.Planning.Owner.Id)
+
+	var p planning.Planning
+	status := call(t, http.MethodGet, srv.URL+"/api/plannings/"+created.PlanningId, "", nil, &p)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, created.PlanningId, p.Id)
+	assert.Len(t, p.Players, 1)
+}
+
+func TestAPIHandler_CreateWithInvalidBody(t *testing.T) {
+	srv := newTestServer(t)
+
+	res, err := http.Post(srv.URL+"/api/plannings", "application/json", strings.NewReader("{"))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	var domainErr planning.Error
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&domainErr))
+	assert.Equal(t, "bad_request", domainErr.Code)
+}
+
+func TestAPIHandler_CreateIgnoresState(t *testing.T) {
+	srv := newTestServer(t)
+
+	var created joinedResponse
+	status := call(t, http.MethodPost, srv.URL+"/api/plannings", "", map[string]interface{}{
+		"id":             "chosen-id",
+		"owner":          map[string]string{"id": "chosen-owner", "name": "owner"},
+		"players":        []map[string]string{{"id": "ghost", "name": "<img src=x onerror=alert(1)>"}},
+		"stories":        []map[string]string{{"id": "s1", "title": "injected"}},
+		"currentStoryId": "s1",
+		"revealed":       true,
+		"votes":          map[string]string{"ghost": "5"},
+		"stats":          map[string]interface{}{"count": 1},
+		"timer":          map[string]interface{}{"deadline": time.Now().Add(time.Hour), "autoReveal": true},
+	}, &created)
+
+	require.Equal(t, http.StatusCreated, status)
+	p := created.Planning
+	assert.NotEqual(t, "chosen-id", p.Id)
+	assert.NotEqual(t, "chosen-owner", p.Owner.Id)
+	require.Len(t, p.Players, 1)
+	assert.Equal(t, p.Owner.Id, p.Players[0].Id)
+	assert.Empty(t, p.Stories)
+	assert.Empty(t, p.CurrentStoryId)
+	assert.False(t, p.Revealed)
+	assert.Empty(t, p.Votes)
+	assert.Nil(t, p.Stats)
+	assert.Nil(t, p.Timer)
+}
+
+func TestAPIHandler_JoinIgnoresId(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+
+	joined := joinPlanning(t, srv, created.PlanningId, "guest")
+	var again joinedResponse
+	status := call(t, http.MethodPost, srv.URL+"/api/plannings/"+created.PlanningId+"/players", "", map[string]string{"id": joined.PlayerId, "name": "impostor"}, &again)
+
+	require.Equal(t, http.StatusCreated, status)
+	assert.NotEqual(t, joined.PlayerId, again.PlayerId)
+	assert.Len(t, again.Planning.Players, 3)
+}
+
+func TestAPIHandler_GetUnknownPlanning(t *testing.T) {
+	srv := newTestServer(t)
+
+	var domainErr planning.Error
+	status := call(t, http.MethodGet, srv.URL+"/api/plannings/unknown", "", nil, &domainErr)
+
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, planning.ErrPlanningNotFound.Code, domainErr.Code)
+}
+
+func TestAPIHandler_JoinedPlayerStaysWithoutConnection(t *testing.T) {
+	srv := newTestServer(t, planningsvc.WithGracePeriod(10*time.Millisecond))
+	created := createPlanning(t, srv)
+	guest := joinPlanning(t, srv, created.PlanningId, "guest")
+
+	time.Sleep(50 * time.Millisecond)
+
+	var p planning.Planning
+	assert.Equal(t, http.StatusOK, call(t, http.MethodGet, srv.URL+"/api/plannings/"+created.PlanningId, "", nil, &p))
+	_, ok := p.Player(guest.PlayerId)
+	assert.True(t, ok, "players who joined over REST are not removed for lacking a connection")
+}
+
+func TestAPIHandler_JoinUnknownPlanning(t *testing.T) {
+	srv := newTestServer(t)
+
+	status := call(t, http.MethodPost, srv.URL+"/api/plannings/unknown/players", "", map[string]string{"name": "guest"}, nil)
+
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestAPIHandler_VoteNeedsToken(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/plannings/"+created.PlanningId+"/votes", strings.NewReader(`{"value":"5"}`))
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, "Bearer", res.Header.Get("WWW-Authenticate"))
+	status := call(t, http.MethodPost, srv.URL+"/api/plannings/"+created.PlanningId+"/votes", "forged", map[string]string{"value": "5"}, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestAPIHandler_TokenOfOtherPlanningIsRejected(t *testing.T) {
+	srv := newTestServer(t)
+	first := createPlanning(t, srv)
+	second := createPlanning(t, srv)
+
+	var domainErr planning.Error
+	status := call(t, http.MethodPost, srv.URL+"/api/plannings/"+second.PlanningId+"/reveal", first.Token, nil, &domainErr)
+
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "wrong_planning", domainErr.Code)
+}
+
+func TestAPIHandler_VoteWithCardNotInDeck(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+
+	var domainErr planning.Error
+	status := call(t, http.MethodPost, srv.URL+"/api/plannings/"+created.PlanningId+"/votes", created.Token, map[string]string{"value": "XL"}, &domainErr)
+
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, planning.ErrInvalidVote.Code, domainErr.Code)
+}
+
+func TestAPIHandler_VoteRevealAndReset(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+	guest := joinPlanning(t, srv, created.PlanningId, "guest")
+	url := srv.URL + "/api/plannings/" + created.PlanningId
+
+	var p planning.Planning
+	status := call(t, http.MethodPost, url+"/votes", guest.Token, map[string]string{"value": "5"}, &p)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "5", p.MyVote)
+	assert.Equal(t, "", p.Votes[guest.PlayerId])
+
+	status = call(t, http.MethodPost, url+"/reveal", guest.Token, nil, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	var revealed planning.Planning
+	status = call(t, http.MethodPost, url+"/reveal", created.Token, nil, &revealed)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, revealed.Revealed)
+	assert.Equal(t, "5", revealed.Votes[guest.PlayerId])
+	require.NotNil(t, revealed.Stats)
+
+	var reset planning.Planning
+	status = call(t, http.MethodPost, url+"/reset", created.Token, nil, &reset)
+	assert.Equal(t, http.StatusOK, status)
+	assert.False(t, reset.Revealed)
+	assert.Empty(t, reset.Votes)
+}
+
+func TestAPIHandler_VoteAfterReveal(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+	url := srv.URL + "/api/plannings/" + created.PlanningId
+	require.Equal(t, http.StatusOK, call(t, http.MethodPost, url+"/votes", created.Token, map[string]string{"value": "5"}, nil))
+	require.Equal(t, http.StatusOK, call(t, http.MethodPost, url+"/reveal", created.Token, nil, nil))
+
+	var domainErr planning.Error
+	status := call(t, http.MethodPost, url+"/votes", created.Token, map[string]string{"value": "8"}, &domainErr)
+
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, planning.ErrRoundRevealed.Code, domainErr.Code)
+	var p planning.Planning
+	call(t, http.MethodGet, url, "", nil, &p)
+	assert.Equal(t, "5", p.Votes[created.PlayerId])
+}
+
+func TestAPIHandler_SetRole(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+	guest := joinPlanning(t, srv, created.PlanningId, "guest")
+	url := srv.URL + "/api/plannings/" + created.PlanningId
+
+	status := call(t, http.MethodPost, url+"/players/"+created.PlayerId+"/role", guest.Token, map[string]string{"role": "observer"}, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	var p struct {
+		Observers []planning.Player `json:"observers"`
+	}
+	status = call(t, http.MethodPost, url+"/players/"+guest.PlayerId+"/role", created.Token, map[string]string{"role": "observer"}, &p)
+	assert.Equal(t, http.StatusOK, status)
+	require.Len(t, p.Observers, 1)
+	assert.Equal(t, guest.PlayerId, p.Observers[0].Id)
+
+	var domainErr planning.Error
+	status = call(t, http.MethodPost, url+"/players/"+guest.PlayerId+"/role", created.Token, map[string]string{"role": "captain"}, &domainErr)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, planning.ErrInvalidRole.Code, domainErr.Code)
+}
+
+func TestAPIHandler_StoriesAndHistory(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+	url := srv.URL + "/api/plannings/" + created.PlanningId
+
+	var p planning.Planning
+	status := call(t, http.MethodPost, url+"/stories", created.Token, map[string]string{"title": "Login", "externalKey": "PROJ-1", "id": "chosen"}, &p)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, p.Stories, 1)
+	assert.NotEqual(t, "chosen", p.Stories[0].Id)
+	assert.Equal(t, "PROJ-1", p.Stories[0].ExternalKey)
+	assert.Equal(t, p.Stories[0].Id, p.CurrentStoryId)
+
+	require.Equal(t, http.StatusOK, call(t, http.MethodPost, url+"/stories", created.Token, map[string]string{"title": "Logout"}, nil))
+	status = call(t, http.MethodPost, url+"/next-story", created.Token, nil, &p)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, p.Stories, 2)
+	assert.Equal(t, p.Stories[1].Id, p.CurrentStoryId)
+	assert.Equal(t, http.StatusConflict, call(t, http.MethodPost, url+"/next-story", created.Token, nil, nil))
+
+	require.Equal(t, http.StatusOK, call(t, http.MethodPost, url+"/votes", created.Token, map[string]string{"value": "5"}, nil))
+	require.Equal(t, http.StatusOK, call(t, http.MethodPost, url+"/reveal", created.Token, nil, nil))
+	status = call(t, http.MethodPost, url+"/estimate", created.Token, map[string]string{"estimate": "5"}, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	var history []planning.Story
+	status = call(t, http.MethodGet, url+"/history", "", nil, &history)
+	assert.Equal(t, http.StatusOK, status)
+	require.Len(t, history, 2)
+	assert.Empty(t, history[0].Rounds)
+	require.Len(t, history[1].Rounds, 1)
+	assert.Equal(t, "5", history[1].Rounds[0].Estimate)
+	assert.Equal(t, http.StatusNotFound, call(t, http.MethodGet, srv.URL+"/api/plannings/unknown/history", "", nil, nil))
+}
+
+func TestAPIHandler_StartTimer(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+	guest := joinPlanning(t, srv, created.PlanningId, "guest")
+	url := srv.URL + "/api/plannings/" + created.PlanningId
+
+	assert.Equal(t, http.StatusForbidden, call(t, http.MethodPost, url+"/timer", guest.Token, map[string]interface{}{"seconds": 60}, nil))
+	assert.Equal(t, http.StatusBadRequest, call(t, http.MethodPost, url+"/timer", created.Token, map[string]interface{}{"seconds": 0}, nil))
+	// Converted to nanoseconds these seconds overflow to about 1.3 seconds
+	assert.Equal(t, http.StatusBadRequest, call(t, http.MethodPost, url+"/timer", created.Token, map[string]interface{}{"seconds": 18446744075}, nil))
+
+	var p planning.Planning
+	status := call(t, http.MethodPost, url+"/timer", created.Token, map[string]interface{}{"seconds": 60, "autoReveal": true}, &p)
+	assert.Equal(t, http.StatusOK, status)
+	require.NotNil(t, p.Timer)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), p.Timer.Deadline, 5*time.Second)
+}
+
+func TestAPIHandler_Close(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+	guest := joinPlanning(t, srv, created.PlanningId, "guest")
+	url := srv.URL + "/api/plannings/" + created.PlanningId
+
+	assert.Equal(t, http.StatusForbidden, call(t, http.MethodDelete, url, guest.Token, nil, nil))
+	assert.Equal(t, http.StatusNoContent, call(t, http.MethodDelete, url, created.Token, nil, nil))
+	assert.Equal(t, http.StatusNotFound, call(t, http.MethodGet, url, "", nil, nil))
+}
+
+func TestAPIHandler_ChangesAreBroadcastToWebsocketClients(t *testing.T) {
+	srv := newTestServer(t)
+	created := createPlanning(t, srv)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?version=1", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "resume", "payload": map[string]string{"token": created.Token}}))
+	readType := func() string {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		var event struct {
+			Type string `json:"type"`
+		}
+		require.NoError(t, conn.ReadJSON(&event))
+		return event.Type
+	}
+	require.Equal(t, "hello", readType())
+	require.Equal(t, "joined", readType())
+	require.Equal(t, "resume", readType())
+
+	guest := joinPlanning(t, srv, created.PlanningId, "guest")
+	assert.Equal(t, "join", readType())
+	call(t, http.MethodPost, srv.URL+"/api/plannings/"+created.PlanningId+"/votes", guest.Token, map[string]string{"value": "5"}, nil)
+	assert.Equal(t, "vote", readType())
+	call(t, http.MethodPost, srv.URL+"/api/plannings/"+created.PlanningId+"/reveal", created.Token, nil, nil)
+	assert.Equal(t, "reveal", readType())
+}
@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"sync"
+)
+
+// Broadcaster passes the messages for a planning to every server instance, so players
+// connected to different instances see the same planning. Each instance delivers the
+// messages to its own connections.
+type Broadcaster interface {
+	// Publish sends a message to the connections of a planning on all instances, this one included
+	Publish(planningId string, msg []byte) error
+	// Subscribe registers a function that delivers published messages to local connections
+	Subscribe(deliver func(planningId string, msg []byte))
+}
+
+// LocalBroadcaster delivers messages within the process only, which is all a single instance needs
+type LocalBroadcaster struct {
+	mu          sync.RWMutex
+	subscribers []func(planningId string, msg []byte)
+}
+
+func NewLocalBroadcaster() *LocalBroadcaster {
+	return &LocalBroadcaster{}
+}
+
+func (b *LocalBroadcaster) Publish(planningId string, msg []byte) error {
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+	for _, deliver := range subscribers {
+		deliver(planningId, msg)
+	}
+	return nil
+}
+
+func (b *LocalBroadcaster) Subscribe(deliver func(planningId string, msg []byte)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, deliver)
+}
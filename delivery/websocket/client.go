@@ -0,0 +1,104 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// client is a single websocket connection. Messages are queued and written by the
+// client's own write pump, so a slow client only delays the messages sent to itself.
+type client struct {
+	conn         *websocket.Conn
+	send         chan []byte
+	done         chan struct{} // done is closed once the client is shut down
+	closeOnce    sync.Once
+	writeTimeout time.Duration
+	pingInterval time.Duration
+	logger       *zap.Logger
+}
+
+func newClient(conn *websocket.Conn, queueSize int, writeTimeout time.Duration, pingInterval time.Duration, logger *zap.Logger) *client {
+	c := &client{
+		conn:         conn,
+		send:         make(chan []byte, queueSize),
+		done:         make(chan struct{}),
+		writeTimeout: writeTimeout,
+		pingInterval: pingInterval,
+		logger:       logger,
+	}
+	go c.writePump()
+	return c
+}
+
+// Send queues a message for the client. A client whose queue is full can't keep up
+// and is disconnected, its read loop then cleans up like for any other closed connection.
+func (c *client) Send(msg []byte) {
+	select {
+	case <-c.done:
+	case c.send <- msg:
+	default:
+		c.logger.Warn("send queue full, disconnecting slow client", zap.String("remoteAddr", c.conn.RemoteAddr().String()))
+		c.close()
+	}
+}
+
+// writePump is the only goroutine writing to the connection, as gorilla allows only one concurrent writer.
+// It also pings the client, the pongs keep the read deadline of the connection from expiring.
+func (c *client) writePump() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.writeTimeout)); err != nil {
+				c.close()
+				return
+			}
+		case msg := <-c.send:
+			if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+				c.close()
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				if !c.closed() {
+					c.logger.Warn("failed to write message, disconnecting client", zap.Error(err))
+				}
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+func (c *client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// reject tells the client why it is disconnected with a close frame, then closes the connection
+func (c *client) reject(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.writeTimeout)); err != nil {
+		c.logger.Warn("failed to write close message", zap.Error(err))
+	}
+	c.close()
+}
+
+// close stops the write pump and closes the connection, which ends the read loop as well
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if err := c.conn.Close(); err != nil {
+			c.logger.Error("failed to close connection", zap.Error(err))
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// ProtocolVersion is the version of the websocket protocol this server speaks. It goes up
+	// whenever messages change in a way that clients written for an older version can't handle.
+	ProtocolVersion = 1
+	// minProtocolVersion is the oldest version clients may still speak
+	minProtocolVersion = 1
+)
+
+// Close codes of connections that fail the handshake, taken from the range reserved for applications
+const (
+	// CloseHandshakeRequired is sent to clients whose first message is not hello
+	CloseHandshakeRequired = 4000
+	// CloseUnsupportedVersion is sent to clients speaking a protocol version this server doesn't
+	CloseUnsupportedVersion = 4001
+)
+
+// features lists what this server supports, so clients can leave out what it doesn't
+var features = []string{"decks", "roles", "observers", "stories", "estimates", "history", "timer", "resume"}
+
+// queryVersion returns the protocol version of the version query parameter, or false if there is none
+func queryVersion(value string) (int, bool) {
+	if value == "" {
+		return 0, false
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, true // an unparsable version is never supported
+	}
+	return version, true
+}
+
+func (h *WebsocketHandler) handleHello(c *client, payload json.RawMessage) bool {
+	var req HelloRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		h.logger.Warn("failed to unmarshal hello payload", zap.Error(err))
+	}
+	return h.hello(c, req.Version)
+}
+
+// hello completes the handshake with the features of the server, or closes the connection
+// if the client speaks an unsupported version
+func (h *WebsocketHandler) hello(c *client, version int) bool {
+	if version < minProtocolVersion || version > ProtocolVersion {
+		h.logger.Info("rejecting client with unsupported protocol version", zap.Int("version", version))
+		c.reject(CloseUnsupportedVersion, fmt.Sprintf("protocol version %d is not supported, use %d to %d", version, minProtocolVersion, ProtocolVersion))
+		return false
+	}
+	h.send(c, "hello", HelloReply{Version: version, Features: features})
+	return true
+}
@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"planning-poker/domain/planning"
+)
+
+func readCloseCode(t *testing.T, conn *websocket.Conn) int {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	return closeErr.Code
+}
+
+func TestWebsocketHandler_HelloAsFirstMessage(t *testing.T) {
+	srv := newTestServer(t)
+	conn := dialWithoutHandshake(t, srv, "")
+
+	sendEvent(t, conn, "hello", map[string]int{"version": ProtocolVersion})
+
+	event := readEvent(t, conn)
+	require.Equal(t, "hello", event.Type)
+	var reply HelloReply
+	require.NoError(t, json.Unmarshal(event.Payload, &reply))
+	assert.Equal(t, ProtocolVersion, reply.Version)
+	assert.Contains(t, reply.Features, "timer")
+	createPlanning(t, conn, "owner")
+}
+
+func TestWebsocketHandler_RejectsUnsupportedVersionInQuery(t *testing.T) {
+	srv := newTestServer(t)
+
+	assert.Equal(t, CloseUnsupportedVersion, readCloseCode(t, dialWithoutHandshake(t, srv, "?version=99")))
+	assert.Equal(t, CloseUnsupportedVersion, readCloseCode(t, dialWithoutHandshake(t, srv, "?version=latest")))
+}
+
+func TestWebsocketHandler_RejectsUnsupportedVersionInHello(t *testing.T) {
+	srv := newTestServer(t)
+	conn := dialWithoutHandshake(t, srv, "")
+
+	sendEvent(t, conn, "hello", map[string]int{"version": ProtocolVersion + 1})
+
+	assert.Equal(t, CloseUnsupportedVersion, readCloseCode(t, conn))
+}
+
+func TestWebsocketHandler_RejectsClientWithoutHandshake(t *testing.T) {
+	srv := newTestServer(t)
+	conn := dialWithoutHandshake(t, srv, "")
+
+	sendEvent(t, conn, "create", map[string]interface{}{"owner": map[string]string{"name": "owner"}})
+
+	assert.Equal(t, CloseHandshakeRequired, readCloseCode(t, conn))
+}
+
+func TestWebsocketHandler_UnknownTypeIsAnswered(t *testing.T) {
+	srv := newTestServer(t)
+	conn := dial(t, srv)
+
+	sendEvent(t, conn, "shuffle", map[string]string{})
+	sendEvent(t, conn, "hello", map[string]int{"version": ProtocolVersion})
+
+	for _, code := range []string{errUnknownType.Code, errAlreadyGreeted.Code} {
+		event := readEvent(t, conn)
+		require.Equal(t, "error", event.Type)
+		var domainErr planning.Error
+		require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+		assert.Equal(t, code, domainErr.Code)
+	}
+}
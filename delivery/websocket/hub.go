@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"planning-poker/application/planningsvc"
+	"planning-poker/domain/planning"
+	"planning-poker/infra"
+)
+
+// Subscriber receives the messages of a planning, e.g. a websocket connection or an event stream
+type Subscriber interface {
+	// Send queues a message for the subscriber, it must not block
+	Send(msg []byte)
+}
+
+// Hub turns the events of the planning service into messages and passes them to the subscribers
+// of each planning, whichever transport they are connected by. The messages reach the other
+// instances through the broadcaster.
+type Hub struct {
+	logger      *zap.Logger
+	broadcaster Broadcaster
+	rooms       map[string]*room // rooms holds the subscribers per planning ID
+	mu          sync.Mutex       // mu guards rooms only, sending is done without holding it
+}
+
+// NewHub creates the hub and subscribes it to the events of the planning service
+func NewHub(planningSvc *planningsvc.PlanningService, broadcaster Broadcaster) *Hub {
+	h := &Hub{
+		logger:      infra.GetLogger(),
+		broadcaster: broadcaster,
+		rooms:       make(map[string]*room),
+	}
+	broadcaster.Subscribe(h.deliver)
+	planningSvc.Subscribe(h)
+	return h
+}
+
+// StartStats logs the number of plannings with subscribers every interval. The returned
+// function stops the logging.
+func (h *Hub) StartStats(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				h.logStats()
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+func (h *Hub) logStats() {
+	h.mu.Lock()
+	activeSessions := len(h.rooms)
+	h.mu.Unlock()
+	h.logger.Info("Active sessions", zap.Int("count", activeSessions))
+}
+
+// Subscribe passes the messages of a planning to the subscriber until it is unsubscribed
+func (h *Hub) Subscribe(planningId string, s Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[planningId]
+	if !ok {
+		r = newRoom()
+		h.rooms[planningId] = r
+	}
+	r.add(s)
+}
+
+func (h *Hub) Unsubscribe(planningId string, s Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r, ok := h.rooms[planningId]; ok && r.remove(s) {
+		delete(h.rooms, planningId)
+	}
+}
+
+// Handle broadcasts an event of the planning service to every subscriber of its planning
+func (h *Hub) Handle(event planning.Event) {
+	switch e := event.(type) {
+	case planning.PlayerJoined:
+		h.broadcast(e.PlanningId(), "join", e.Planning)
+	case planning.PlayerLeft:
+		h.broadcast(e.PlanningId(), "player_left", e.Planning)
+	case planning.OwnerChanged:
+		h.broadcast(e.PlanningId(), "owner_changed", e.Planning)
+	case planning.RoleChanged:
+		h.broadcast(e.PlanningId(), "set_role", e.Planning)
+	case planning.VoteCast:
+		h.broadcast(e.PlanningId(), "vote", e.Planning)
+	case planning.VotesRevealed:
+		h.broadcast(e.PlanningId(), "reveal", e.Planning)
+	case planning.RoundReset:
+		h.broadcast(e.PlanningId(), "reset", e.Planning)
+	case planning.StoryAdded:
+		h.broadcast(e.PlanningId(), "add_story", e.Planning)
+	case planning.CurrentStoryChanged:
+		h.broadcast(e.PlanningId(), "next_story", e.Planning)
+	case planning.EstimateSet:
+		h.broadcast(e.PlanningId(), "set_estimate", e.Planning)
+	case planning.TimerStarted:
+		h.broadcast(e.PlanningId(), "timer_started", e.Planning)
+	case planning.TimerExpired:
+		h.broadcast(e.PlanningId(), "timer_expired", e.Planning)
+	case planning.PlanningClosed:
+		h.broadcast(e.PlanningId(), "close", ClosedMessage{PlanningId: e.Id, Reason: e.Reason})
+	}
+}
+
+// broadcast sends an event to the subscribers of a planning on every instance
+func (h *Hub) broadcast(planningId string, eventType string, payload interface{}) {
+	msg, err := newMessage(eventType, "", payload)
+	if err != nil {
+		h.logger.Error("failed to marshal broadcast event", zap.Error(err))
+		return
+	}
+
+	if err := h.broadcaster.Publish(planningId, msg); err != nil {
+		h.logger.Error("failed to publish broadcast event", zap.String("planningId", planningId), zap.Error(err))
+	}
+}
+
+// deliver sends a published message to the subscribers of a planning on this instance
+func (h *Hub) deliver(planningId string, msg []byte) {
+	h.mu.Lock()
+	r, ok := h.rooms[planningId]
+	h.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	for _, s := range r.snapshot() {
+		s.Send(msg)
+	}
+}
+
+// room holds the subscribers of a single planning. Every planning has its own lock,
+// so broadcasting to one planning doesn't wait for another.
+type room struct {
+	mu          sync.Mutex
+	subscribers map[Subscriber]bool
+}
+
+func newRoom() *room {
+	return &room{subscribers: make(map[Subscriber]bool)}
+}
+
+func (r *room) add(s Subscriber) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers[s] = true
+}
+
+// remove deletes a subscriber and reports whether the room is empty afterwards
+func (r *room) remove(s Subscriber) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subscribers, s)
+	return len(r.subscribers) == 0
+}
+
+// snapshot returns the current subscribers, so they can be sent to without holding the lock
+func (r *room) snapshot() []Subscriber {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subscribers := make([]Subscriber, 0, len(r.subscribers))
+	for s := range r.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	return subscribers
+}
@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"encoding/json"
+
+	"planning-poker/domain/planning"
+)
+
+// Message is the envelope of every message, in both directions. The type tells how to read the payload.
+// Clients may set a request ID on commands, the server then echoes it in the ack or error reply.
+type Message struct {
+	Type      string          `json:"type"`
+	RequestId string          `json:"requestId,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// inboundPayloads maps the type of every message a client may send to its payload
+var inboundPayloads = map[string]any{
+	"hello":        HelloRequest{},
+	"create":       CreateRequest{},
+	"join":         JoinRequest{},
+	"resume":       ResumeRequest{},
+	"vote":         VoteRequest{},
+	"reveal":       PlanningRequest{},
+	"reset":        PlanningRequest{},
+	"close":        PlanningRequest{},
+	"set_role":     SetRoleRequest{},
+	"add_story":    AddStoryRequest{},
+	"next_story":   PlanningRequest{},
+	"set_estimate": SetEstimateRequest{},
+	"start_timer":  StartTimerRequest{},
+	"history":      PlanningRequest{},
+}
+
+// outboundPayloads maps the type of every message the server sends to its payload
+var outboundPayloads = map[string]any{
+	"hello":         HelloReply{},
+	"joined":        JoinedReply{},
+	"ack":           AckReply{},
+	"error":         planning.Error{},
+	"close":         ClosedMessage{},
+	"history":       []planning.Story{},
+	"create":        planning.Planning{},
+	"join":          planning.Planning{},
+	"resume":        planning.Planning{},
+	"player_left":   planning.Planning{},
+	"owner_changed": planning.Planning{},
+	"set_role":      planning.Planning{},
+	"vote":          planning.Planning{},
+	"reveal":        planning.Planning{},
+	"reset":         planning.Planning{},
+	"add_story":     planning.Planning{},
+	"next_story":    planning.Planning{},
+	"set_estimate":  planning.Planning{},
+	"timer_started": planning.Planning{},
+	"timer_expired": planning.Planning{},
+}
+
+// HelloRequest is the payload of "hello", the first message of a client unless it passes
+// the version as query parameter when connecting
+type HelloRequest struct {
+	Version int `json:"version"`
+}
+
+// HelloReply is the payload of "hello", it completes the handshake with the version both sides
+// speak and the features of the server
+type HelloReply struct {
+	Version  int      `json:"version"`
+	Features []string `json:"features"`
+}
+
+// NewPlayer is a player as sent by a client, the server assigns the ID
+type NewPlayer struct {
+	Name string        `json:"name"`
+	Role planning.Role `json:"role,omitempty"` // Role defaults to voter
+}
+
+// Player returns the player to join with, only the fields a client may choose are taken over
+func (p NewPlayer) Player() planning.Player {
+	return planning.Player{Name: p.Name, Role: p.Role}
+}
+
+// NewDeck picks one of the predefined decks or lists the cards of a custom deck
+type NewDeck struct {
+	Type  planning.DeckType `json:"type,omitempty"` // Type defaults to fibonacci
+	Cards []planning.Card   `json:"cards,omitempty"`
+}
+
+// CreateRequest is the payload of "create"
+type CreateRequest struct {
+	Owner    NewPlayer         `json:"owner"`
+	Deck     NewDeck           `json:"deck,omitempty"`
+	Settings planning.Settings `json:"settings,omitempty"`
+}
+
+// Planning returns the planning to create, only the fields a client may choose are taken over
+func (r CreateRequest) Planning() planning.Planning {
+	return planning.Planning{
+		Owner:    r.Owner.Player(),
+		Deck:     planning.Deck{Type: r.Deck.Type, Cards: r.Deck.Cards},
+		Settings: r.Settings,
+	}
+}
+
+// JoinRequest is the payload of "join"
+type JoinRequest struct {
+	PlanningId string    `json:"planningId"`
+	Player     NewPlayer `json:"player"`
+}
+
+// ResumeRequest is the payload of "resume", the token is the one of the joined reply
+type ResumeRequest struct {
+	Token string `json:"token"`
+}
+
+// PlanningRequest is the payload of commands that need nothing but the planning, and part of
+// every other command sent after create or join. The planning ID is optional and only checked
+// against the planning the connection is bound to.
+type PlanningRequest struct {
+	PlanningId string `json:"planningId,omitempty"`
+}
+
+func (r PlanningRequest) check(planningId string) error {
+	if planningId == "" {
+		return errNotJoined
+	}
+	if r.PlanningId != "" && r.PlanningId != planningId {
+		return errWrongPlanning
+	}
+	return nil
+}
+
+// VoteRequest is the payload of "vote", the value is the ID of a card of the deck
+type VoteRequest struct {
+	PlanningRequest
+	Value string `json:"value"`
+}
+
+// SetRoleRequest is the payload of "set_role"
+type SetRoleRequest struct {
+	PlanningRequest
+	PlayerId string        `json:"playerId"`
+	Role     planning.Role `json:"role"`
+}
+
+// NewStory is a story as sent by a client, the server assigns the ID
+type NewStory struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ExternalKey string `json:"externalKey,omitempty"`
+}
+
+// Story returns the story to add, only the fields a client may choose are taken over
+func (s NewStory) Story() planning.Story {
+	return planning.Story{Title: s.Title, Description: s.Description, ExternalKey: s.ExternalKey}
+}
+
+// AddStoryRequest is the payload of "add_story"
+type AddStoryRequest struct {
+	PlanningRequest
+	Story NewStory `json:"story"`
+}
+
+// SetEstimateRequest is the payload of "set_estimate", the estimate is the ID of a card of the deck
+type SetEstimateRequest struct {
+	PlanningRequest
+	Estimate string `json:"estimate"`
+}
+
+// StartTimerRequest is the payload of "start_timer"
+type StartTimerRequest struct {
+	PlanningRequest
+	Seconds    int  `json:"seconds"`
+	AutoReveal bool `json:"autoReveal,omitempty"`
+}
+
+// JoinedReply is the payload of "joined", sent to the connection that created, joined or resumed a planning
+type JoinedReply struct {
+	PlanningId string `json:"planningId"`
+	PlayerId   string `json:"playerId"`
+	Token      string `json:"token"`
+	MyVote     string `json:"myVote"`
+}
+
+// AckReply is the payload of "ack", sent once a command with a request ID succeeded
+type AckReply struct {
+	Type string `json:"type"`
+}
+
+// ClosedMessage is the payload of "close", sent once the planning is gone
+type ClosedMessage struct {
+	PlanningId string               `json:"planningId"`
+	Reason     planning.CloseReason `json:"reason"`
+}
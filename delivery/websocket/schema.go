@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"planning-poker/domain/planning"
+)
+
+// Schema returns the JSON Schema of the websocket protocol, generated from the message types.
+// "#/$defs/inbound" describes the messages clients send, "#/$defs/outbound" the messages they receive.
+func Schema() map[string]any {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	g.defs["inbound"] = g.messages(inboundPayloads)
+	g.defs["outbound"] = g.messages(outboundPayloads)
+	return map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "Planning poker websocket protocol",
+		"description": "Every message is an envelope of a type and a payload, the type tells how to read the payload.",
+		"anyOf":       []any{ref("inbound"), ref("outbound")},
+		"$defs":       g.defs,
+	}
+}
+
+// SchemaHandler serves the JSON Schema of the websocket protocol
+func SchemaHandler() http.Handler {
+	schema, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		panic(err) // the schema only depends on the message types
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/schema+json")
+		_, _ = w.Write(schema)
+	})
+}
+
+// schemaGenerator describes Go types the way encoding/json writes them. Structs are
+// collected in defs by their type name and referenced from everywhere they are used.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+// messages describes the envelopes of the given message types, types sharing a payload are listed together
+func (g *schemaGenerator) messages(payloads map[string]any) map[string]any {
+	types := make(map[reflect.Type][]string)
+	var order []reflect.Type
+	for _, messageType := range slices.Sorted(maps.Keys(payloads)) {
+		t := reflect.TypeOf(payloads[messageType])
+		if _, ok := types[t]; !ok {
+			order = append(order, t)
+		}
+		types[t] = append(types[t], messageType)
+	}
+	var envelopes []any
+	for _, t := range order {
+		envelopes = append(envelopes, map[string]any{
+			"type":     "object",
+			"required": []string{"type", "payload"},
+			"properties": map[string]any{
+				"type":      map[string]any{"enum": types[t]},
+				"requestId": map[string]any{"type": "string"},
+				"payload":   g.schemaOf(t),
+			},
+		})
+	}
+	return map[string]any{"oneOf": envelopes}
+}
+
+func (g *schemaGenerator) schemaOf(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{g.schemaOf(t.Elem()), map[string]any{"type": "null"}}}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		// nil slices and maps are written as null
+		return map[string]any{"type": []string{"array", "null"}, "items": g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // reserves the name while the fields are described
+			g.defs[t.Name()] = g.object(t)
+		}
+		return ref(t.Name())
+	default:
+		panic(fmt.Sprintf("no schema for %s", t))
+	}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := make([]string, 0)
+	g.fields(t, properties, &required)
+	if t == reflect.TypeOf(planning.Planning{}) {
+		// Planning.MarshalJSON lists the observers separately from the players that vote
+		properties["observers"] = g.schemaOf(reflect.TypeOf([]planning.Player{}))
+		required = append(required, "observers")
+	}
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+// fields describes the fields of a struct, fields of embedded structs are inlined like encoding/json does
+func (g *schemaGenerator) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			g.fields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schemaOf(field.Type)
+		if !slices.Contains(strings.Split(options, ","), "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var compileSchemas = sync.OnceValues(func() (*jsonschema.Schema, *jsonschema.Schema) {
+	data, err := json.Marshal(Schema())
+	if err != nil {
+		panic(err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("protocol.json", doc); err != nil {
+		panic(err)
+	}
+	return c.MustCompile("protocol.json#/$defs/inbound"), c.MustCompile("protocol.json#/$defs/outbound")
+})
+
+func validate(t testing.TB, schema *jsonschema.Schema, msg []byte) error {
+	t.Helper()
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(msg))
+	require.NoError(t, err)
+	return schema.Validate(inst)
+}
+
+// validateOutbound fails the test if a message of the server does not match the schema
+func validateOutbound(t testing.TB, msg []byte) {
+	t.Helper()
+	_, outbound := compileSchemas()
+	require.NoError(t, validate(t, outbound, msg), "message does not match the schema: %s", msg)
+}
+
+func TestSchemaHandler_ServesSchema(t *testing.T) {
+	srv := httptest.NewServer(SchemaHandler())
+	t.Cleanup(srv.Close)
+
+	res, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/schema+json", res.Header.Get("Content-Type"))
+	var schema struct {
+		Defs map[string]json.RawMessage `json:"$defs"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&schema))
+	assert.Contains(t, schema.Defs, "inbound")
+	assert.Contains(t, schema.Defs, "outbound")
+	assert.Contains(t, schema.Defs, "Planning")
+}
+
+func TestSchema_AcceptsMessagesOfTheFrontend(t *testing.T) {
+	inbound, _ := compileSchemas()
+
+	for _, msg := range []string{
+		`{"type":"hello","payload":{"version":1}}`,
+		`{"type":"create","payload":{"name":"Planning Session","owner":{"name":"Ann","role":"voter"},"settings":{"autoReveal":true}}}`,
+		`{"type":"join","payload":{"planningId":"p1","player":{"name":"Bob","role":"observer"}}}`,
+		`{"type":"resume","payload":{"token":"a.b.c"}}`,
+		`{"type":"vote","requestId":"1","payload":{"planningId":"p1","value":"5"}}`,
+		`{"type":"reveal","payload":{"planningId":"p1"}}`,
+		`{"type":"next_story","payload":{}}`,
+		`{"type":"add_story","payload":{"story":{"title":"Login"}}}`,
+		`{"type":"start_timer","payload":{"seconds":60,"autoReveal":true}}`,
+	} {
+		assert.NoError(t, validate(t, inbound, []byte(msg)), msg)
+	}
+}
+
+func TestSchema_RejectsInvalidMessages(t *testing.T) {
+	inbound, outbound := compileSchemas()
+
+	assert.Error(t, validate(t, inbound, []byte(`{"type":"unknown","payload":{}}`)))
+	assert.Error(t, validate(t, inbound, []byte(`{"type":"vote","payload":{"value":5}}`)))
+	assert.Error(t, validate(t, inbound, []byte(`{"type":"vote"}`)))
+	assert.Error(t, validate(t, outbound, []byte(`{"type":"reveal","payload":{"id":"p1"}}`)))
+	assert.Error(t, validate(t, outbound, []byte(`{"type":"close","payload":{"planningId":"p1"}}`)))
+}
@@ -2,11 +2,13 @@ package websocket
 
 import (
 	"encoding/json"
-	"sync"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
+	"net"
 	"net/http"
 	"planning-poker/application/planningsvc"
 	"planning-poker/domain/planning"
@@ -19,83 +21,150 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+var (
+	errNotJoined      = &planning.Error{Code: "not_joined", Message: "connection has not joined a planning"}
+	errWrongPlanning  = &planning.Error{Code: "wrong_planning", Message: "connection is bound to a different planning"}
+	errUnknownType    = &planning.Error{Code: "unknown_type", Message: "unknown message type"}
+	errAlreadyGreeted = &planning.Error{Code: "already_greeted", Message: "connection already completed the handshake"}
+	errBadRequest     = &planning.Error{Code: "bad_request", Message: "message is invalid"}
+	errInternal       = &planning.Error{Code: "internal_error", Message: "something went wrong"}
+)
+
 type WebsocketHandler struct {
-	planningSvc *planningsvc.PlanningService
-	logger      *zap.Logger
-	sessions    map[string]map[*websocket.Conn]bool
-	mu          sync.Mutex
+	planningSvc  *planningsvc.PlanningService
+	logger       *zap.Logger
+	hub          *Hub
+	queueSize    int
+	writeTimeout time.Duration
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	readLimit    int64
 }
 
-func NewWebsocketHandler(planningSvc *planningsvc.PlanningService) *WebsocketHandler {
-	handler := &WebsocketHandler{
-		planningSvc: planningSvc,
-		logger:      infra.GetLogger(),
-		sessions:    make(map[string]map[*websocket.Conn]bool),
+type Option func(*WebsocketHandler)
+
+// WithSendQueueSize sets how many outgoing messages are buffered per connection.
+// Connections that fall further behind are disconnected.
+func WithSendQueueSize(size int) Option {
+	return func(h *WebsocketHandler) {
+		h.queueSize = size
 	}
-	go handler.Stats() // Start the stats logging in a separate goroutine
-	return handler
 }
 
-// Stats logs the current number of active sessions
-func (h *WebsocketHandler) Stats() {
-	for {
-		h.mu.Lock()
-		activeSessions := len(h.sessions)
-		h.mu.Unlock()
-		h.logger.Info("Active WebSocket sessions", zap.Int("count", activeSessions))
-		// Sleep for a while before logging again
-		time.Sleep(10 * time.Second)
+// WithWriteTimeout sets how long writing a single message to a connection may take
+func WithWriteTimeout(d time.Duration) Option {
+	return func(h *WebsocketHandler) {
+		h.writeTimeout = d
 	}
 }
 
-func (h *WebsocketHandler) register(planningId string, conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	if _, ok := h.sessions[planningId]; !ok {
-		h.sessions[planningId] = make(map[*websocket.Conn]bool)
+// WithHeartbeat sets how often connections are pinged and how long to wait for any pong
+// or message before a connection counts as dead. pingInterval has to be less than pongTimeout.
+func WithHeartbeat(pingInterval time.Duration, pongTimeout time.Duration) Option {
+	return func(h *WebsocketHandler) {
+		h.pingInterval = pingInterval
+		h.pongTimeout = pongTimeout
 	}
-	h.sessions[planningId][conn] = true
 }
 
-func (h *WebsocketHandler) unregister(planningId string, conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	if conns, ok := h.sessions[planningId]; ok {
-		delete(conns, conn)
-		if len(conns) == 0 {
-			delete(h.sessions, planningId)
-		}
+// WithReadLimit sets the maximum size in bytes of an incoming message. Connections
+// sending larger messages are closed.
+func WithReadLimit(limit int64) Option {
+	return func(h *WebsocketHandler) {
+		h.readLimit = limit
 	}
 }
 
-func (h *WebsocketHandler) broadcast(planningId string, eventType string, payload interface{}) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// WithHub sets the hub the connections subscribe to, so they share it with other transports.
+// By default the handler has a hub of its own that only reaches the connections of this instance.
+func WithHub(hub *Hub) Option {
+	return func(h *WebsocketHandler) {
+		h.hub = hub
+	}
+}
 
-	conns, ok := h.sessions[planningId]
-	if !ok {
-		return
+func NewWebsocketHandler(planningSvc *planningsvc.PlanningService, opts ...Option) *WebsocketHandler {
+	handler := &WebsocketHandler{
+		planningSvc:  planningSvc,
+		logger:       infra.GetLogger(),
+		queueSize:    64,
+		writeTimeout: 10 * time.Second,
+		pingInterval: 50 * time.Second,
+		pongTimeout:  60 * time.Second,
+		readLimit:    64 * 1024,
+	}
+	for _, opt := range opts {
+		opt(handler)
 	}
+	if handler.hub == nil {
+		handler.hub = NewHub(planningSvc, NewLocalBroadcaster())
+	}
+	return handler
+}
 
-	event := struct {
-		Type    string      `json:"type"`
-		Payload interface{} `json:"payload"`
-	}{
-		Type:    eventType,
-		Payload: payload,
+// unbind detaches a connection from its player. The player is removed from the planning
+// once the reconnect grace period passes without a new connection.
+func (h *WebsocketHandler) unbind(c *client, planningId string, playerId string) {
+	h.hub.Unsubscribe(planningId, c)
+	h.planningSvc.Disconnect(planningId, playerId)
+}
+
+// send writes an event to a single connection only
+func (h *WebsocketHandler) send(c *client, eventType string, payload interface{}) {
+	h.reply(c, eventType, "", payload)
+}
+
+// reply writes an event to a single connection, echoing the ID of the request it answers
+func (h *WebsocketHandler) reply(c *client, eventType string, requestId string, payload interface{}) {
+	msg, err := newMessage(eventType, requestId, payload)
+	if err != nil {
+		h.logger.Error("failed to marshal event", zap.Error(err))
+		return
 	}
 
-	msg, err := json.Marshal(event)
+	c.Send(msg)
+}
+
+// sendJoined tells a connection which player it is bound to and how to resume after a disconnect.
+// The planning follows as a reply of the given type, as the connection missed the events so far.
+func (h *WebsocketHandler) sendJoined(c *client, replyType string, planningId string, playerId string) {
+	p, err := h.planningSvc.GetById(planningId, playerId)
 	if err != nil {
-		h.logger.Error("failed to marshal broadcast event", zap.Error(err))
+		h.logger.Error("failed to get planning for joined reply", zap.Error(err))
 		return
 	}
+	h.send(c, "joined", JoinedReply{
+		PlanningId: planningId,
+		PlayerId:   playerId,
+		Token:      h.planningSvc.ReconnectToken(planningId, playerId),
+		MyVote:     p.MyVote,
+	})
+	h.send(c, replyType, p)
+}
 
-	for conn := range conns {
-		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			h.logger.Error("failed to write message during broadcast", zap.Error(err))
-		}
+// sendError replies with an error event to the connection that caused it. Errors other than
+// domain errors and invalid JSON are logged and not passed on to the client.
+func (h *WebsocketHandler) sendError(c *client, requestId string, err error) {
+	var domainErr *planning.Error
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &domainErr):
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		domainErr = &planning.Error{Code: errBadRequest.Code, Message: err.Error()}
+	default:
+		h.logger.Error("failed to handle event", zap.Error(err))
+		domainErr = errInternal
 	}
+	h.reply(c, "error", requestId, domainErr)
+}
+
+func newMessage(eventType string, requestId string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Message{Type: eventType, RequestId: requestId, Payload: data})
 }
 
 func (h *WebsocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -104,178 +173,360 @@ func (h *WebsocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.logger.Error("failed to upgrade connection", zap.Error(err))
 		return
 	}
-	defer func(conn *websocket.Conn) {
-		err := conn.Close()
-		if err != nil {
-			h.logger.Error("failed to close connection", zap.Error(err))
-		}
-	}(conn)
+	c := newClient(conn, h.queueSize, h.writeTimeout, h.pingInterval, h.logger)
+	defer c.close()
+
+	// A connection that stays silent longer than the pong timeout is dead, e.g. a laptop that went to sleep.
+	// The failing read below then removes its player like any other disconnect.
+	conn.SetReadLimit(h.readLimit)
+	extendDeadline := func() error {
+		return conn.SetReadDeadline(time.Now().Add(h.pongTimeout))
+	}
+	if err := extendDeadline(); err != nil {
+		h.logger.Error("failed to set read deadline", zap.Error(err))
+		return
+	}
+	conn.SetPongHandler(func(string) error { return extendDeadline() })
 
 	var planningId string
 	var playerId string
 
 	defer func() {
 		if planningId != "" {
-			h.unregister(planningId, conn)
-			if p, err := h.planningSvc.Leave(planningId, playerId); err == nil {
-				h.broadcast(planningId, "player_left", p)
-			}
+			h.unbind(c, planningId, playerId)
 		}
 	}()
 
+	// Clients announce their protocol version in the query when connecting or with hello as first message
+	version, handshaken := queryVersion(r.URL.Query().Get("version"))
+	if handshaken && !h.hello(c, version) {
+		return
+	}
+
+	bind := func(replyType string, newPlanningId string, newPlayerId string) {
+		if planningId != "" {
+			h.unbind(c, planningId, playerId)
+		}
+		planningId = newPlanningId
+		playerId = newPlayerId
+		h.hub.Subscribe(planningId, c)
+		h.sendJoined(c, replyType, planningId, playerId)
+	}
+
 	for {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				h.logger.Error("unexpected close error", zap.Error(err))
 			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				h.logger.Info("connection timed out", zap.String("planningId", planningId), zap.String("playerId", playerId))
+			}
 			break
 		}
-
-		var event struct {
-			Type    string          `json:"type"`
-			Payload json.RawMessage `json:"payload"`
+		if err := extendDeadline(); err != nil {
+			break
 		}
 
+		var event Message
 		if err := json.Unmarshal(msg, &event); err != nil {
-			h.logger.Error("failed to unmarshal event", zap.Error(err))
+			h.logger.Warn("failed to unmarshal event", zap.Error(err))
+			h.sendError(c, "", err)
+			continue
+		}
+		if !handshaken {
+			if event.Type != "hello" {
+				c.reject(CloseHandshakeRequired, "send hello with the protocol version first")
+				break
+			}
+			if !h.handleHello(c, event.Payload) {
+				break
+			}
+			handshaken = true
 			continue
 		}
 
+		// Changes reach the connections as events of the planning service, so only errors and
+		// acknowledgements of requests with an ID are replied here
 		var newPlanningId string
 		var newPlayerId string
-		var ok bool
 
 		switch event.Type {
+		case "hello":
+			err = errAlreadyGreeted
 		case "create":
-			newPlanningId, newPlayerId, ok = h.handleCreate(event.Payload)
-			if ok {
-				planningId = newPlanningId
-				playerId = newPlayerId
-				h.register(planningId, conn)
+			newPlanningId, newPlayerId, err = h.handleCreate(event.Payload)
+			if err == nil {
+				bind(event.Type, newPlanningId, newPlayerId)
 			}
 		case "join":
-			newPlanningId, newPlayerId, ok = h.handleJoin(event.Payload)
-			if ok {
-				if planningId != "" && planningId != newPlanningId {
-					h.unregister(planningId, conn)
-				}
-				planningId = newPlanningId
-				playerId = newPlayerId
-				h.register(planningId, conn)
+			newPlanningId, newPlayerId, err = h.handleJoin(event.Payload)
+			if err == nil {
+				bind(event.Type, newPlanningId, newPlayerId)
+			}
+		case "resume":
+			newPlanningId, newPlayerId, err = h.handleResume(event.Payload)
+			if err == nil {
+				bind(event.Type, newPlanningId, newPlayerId)
 			}
 		case "vote":
-			h.handleVote(event.Payload)
+			err = h.handleVote(event.Payload, planningId, playerId)
 		case "reveal":
-			h.handleReveal(event.Payload)
+			err = h.handleReveal(event.Payload, planningId, playerId)
 		case "reset":
-			h.handleReset(event.Payload)
+			err = h.handleReset(event.Payload, planningId, playerId)
 		case "close":
-			h.handleClose(event.Payload)
+			err = h.handleClose(event.Payload, planningId, playerId)
+		case "set_role":
+			err = h.handleSetRole(event.Payload, planningId, playerId)
+		case "add_story":
+			err = h.handleAddStory(event.Payload, planningId, playerId)
+		case "next_story":
+			err = h.handleNextStory(event.Payload, planningId, playerId)
+		case "set_estimate":
+			err = h.handleSetEstimate(event.Payload, planningId, playerId)
+		case "start_timer":
+			err = h.handleStartTimer(event.Payload, planningId, playerId)
+		case "history":
+			// History is only sent to the client that asked for it
+			err = h.handleHistory(c, event.Payload, planningId)
 		default:
 			h.logger.Warn("unknown event type", zap.String("type", event.Type))
+			err = &planning.Error{Code: errUnknownType.Code, Message: fmt.Sprintf("unknown message type %q", event.Type)}
 		}
-
-		if planningId != "" {
-			p, err := h.planningSvc.GetById(planningId, "")
-			if err != nil {
-				h.logger.Error("failed to get planning for broadcast", zap.Error(err))
-				continue
-			}
-			h.broadcast(planningId, event.Type, p)
+		if err != nil {
+			h.sendError(c, event.RequestId, err)
+		} else if event.RequestId != "" {
+			h.reply(c, "ack", event.RequestId, AckReply{Type: event.Type})
 		}
 	}
 }
 
-func (h *WebsocketHandler) handleCreate(payload json.RawMessage) (string, string, bool) {
-	var p planning.Planning
-	if err := json.Unmarshal(payload, &p); err != nil {
+func (h *WebsocketHandler) handleCreate(payload json.RawMessage) (string, string, error) {
+	var req CreateRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
 		h.logger.Error("failed to unmarshal create payload", zap.Error(err))
-		return "", "", false
+		return "", "", err
 	}
 
+	p := req.Planning()
 	if err := h.planningSvc.Create(&p); err != nil {
 		h.logger.Error("failed to create planning", zap.Error(err))
-		return "", "", false
+		return "", "", err
 	}
+	h.planningSvc.Connect(p.Id, p.Owner.Id)
 
-	return p.Id, p.Owner.Id, true
+	return p.Id, p.Owner.Id, nil
 }
 
-func (h *WebsocketHandler) handleJoin(payload json.RawMessage) (string, string, bool) {
-	var req struct {
-		PlanningId string          `json:"planningId"`
-		Player     planning.Player `json:"player"`
-	}
-
+func (h *WebsocketHandler) handleJoin(payload json.RawMessage) (string, string, error) {
+	var req JoinRequest
 	if err := json.Unmarshal(payload, &req); err != nil {
 		h.logger.Error("failed to unmarshal join payload", zap.Error(err))
-		return "", "", false
+		return "", "", err
 	}
 
-	_, err := h.planningSvc.Join(req.PlanningId, &req.Player)
+	player := req.Player.Player()
+	_, err := h.planningSvc.Join(req.PlanningId, &player)
 	if err != nil {
 		h.logger.Error("failed to join planning", zap.Error(err))
-		return "", "", false
+		return "", "", err
 	}
+	h.planningSvc.Connect(req.PlanningId, player.Id)
 
-	return req.PlanningId, req.Player.Id, true
+	return req.PlanningId, player.Id, nil
 }
 
-func (h *WebsocketHandler) handleVote(payload json.RawMessage) {
-	var req struct {
-		PlanningId string `json:"planningId"`
-		PlayerId   string `json:"playerId"`
-		Value      int    `json:"value"`
+func (h *WebsocketHandler) handleResume(payload json.RawMessage) (string, string, error) {
+	var req ResumeRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		h.logger.Error("failed to unmarshal resume payload", zap.Error(err))
+		return "", "", err
+	}
+
+	p, player, err := h.planningSvc.Resume(req.Token)
+	if err != nil {
+		h.logger.Warn("failed to resume planning", zap.Error(err))
+		return "", "", err
 	}
 
+	return p.Id, player.Id, nil
+}
+
+func (h *WebsocketHandler) handleVote(payload json.RawMessage, planningId string, playerId string) error {
+	var req VoteRequest
 	if err := json.Unmarshal(payload, &req); err != nil {
 		h.logger.Error("failed to unmarshal vote payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
 	}
 
-	if err := h.planningSvc.Vote(req.PlanningId, req.PlayerId, req.Value); err != nil {
+	if _, err := h.planningSvc.Vote(planningId, playerId, req.Value); err != nil {
 		h.logger.Error("failed to vote", zap.Error(err))
+		return err
 	}
+	return nil
 }
 
-func (h *WebsocketHandler) handleReveal(payload json.RawMessage) {
-	var req struct {
-		PlanningId string `json:"planningId"`
-	}
-
+func (h *WebsocketHandler) handleReveal(payload json.RawMessage, planningId string, playerId string) error {
+	var req PlanningRequest
 	if err := json.Unmarshal(payload, &req); err != nil {
 		h.logger.Error("failed to unmarshal reveal payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
 	}
 
-	if _, err := h.planningSvc.RevealVotes(req.PlanningId); err != nil {
+	if _, err := h.planningSvc.RevealVotes(planningId, playerId); err != nil {
 		h.logger.Error("failed to reveal votes", zap.Error(err))
+		return err
 	}
+	return nil
 }
 
-func (h *WebsocketHandler) handleReset(payload json.RawMessage) {
-	var req struct {
-		PlanningId string `json:"planningId"`
-	}
-
+func (h *WebsocketHandler) handleReset(payload json.RawMessage, planningId string, playerId string) error {
+	var req PlanningRequest
 	if err := json.Unmarshal(payload, &req); err != nil {
 		h.logger.Error("failed to unmarshal reset payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
 	}
 
-	if err := h.planningSvc.ResetVotes(req.PlanningId); err != nil {
+	if err := h.planningSvc.ResetVotes(planningId, playerId); err != nil {
 		h.logger.Error("failed to reset votes", zap.Error(err))
+		return err
 	}
+	return nil
 }
 
-func (h *WebsocketHandler) handleClose(payload json.RawMessage) {
-	var req struct {
-		PlanningId string `json:"planningId"`
-	}
-
+func (h *WebsocketHandler) handleClose(payload json.RawMessage, planningId string, playerId string) error {
+	var req PlanningRequest
 	if err := json.Unmarshal(payload, &req); err != nil {
 		h.logger.Error("failed to unmarshal close payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
 	}
 
-	if err := h.planningSvc.Close(req.PlanningId); err != nil {
+	if err := h.planningSvc.Close(planningId, playerId); err != nil {
 		h.logger.Error("failed to close planning", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (h *WebsocketHandler) handleSetRole(payload json.RawMessage, planningId string, playerId string) error {
+	var req SetRoleRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		h.logger.Error("failed to unmarshal set_role payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
+	}
+
+	if _, err := h.planningSvc.SetRole(planningId, playerId, req.PlayerId, req.Role); err != nil {
+		h.logger.Error("failed to set role", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (h *WebsocketHandler) handleAddStory(payload json.RawMessage, planningId string, playerId string) error {
+	var req AddStoryRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		h.logger.Error("failed to unmarshal add_story payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
+	}
+
+	story := req.Story.Story()
+	if _, err := h.planningSvc.AddStory(planningId, playerId, &story); err != nil {
+		h.logger.Error("failed to add story", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (h *WebsocketHandler) handleNextStory(payload json.RawMessage, planningId string, playerId string) error {
+	var req PlanningRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		h.logger.Error("failed to unmarshal next_story payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
+	}
+
+	if _, err := h.planningSvc.NextStory(planningId, playerId); err != nil {
+		h.logger.Error("failed to move to next story", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (h *WebsocketHandler) handleSetEstimate(payload json.RawMessage, planningId string, playerId string) error {
+	var req SetEstimateRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		h.logger.Error("failed to unmarshal set_estimate payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
+	}
+
+	if _, err := h.planningSvc.SetEstimate(planningId, playerId, req.Estimate); err != nil {
+		h.logger.Error("failed to set estimate", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (h *WebsocketHandler) handleHistory(c *client, payload json.RawMessage, planningId string) error {
+	var req PlanningRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		h.logger.Error("failed to unmarshal history payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
+	}
+
+	stories, err := h.planningSvc.History(planningId)
+	if err != nil {
+		h.logger.Error("failed to get history", zap.Error(err))
+		return err
+	}
+	h.send(c, "history", stories)
+	return nil
+}
+
+func (h *WebsocketHandler) handleStartTimer(payload json.RawMessage, planningId string, playerId string) error {
+	var req StartTimerRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		h.logger.Error("failed to unmarshal start_timer payload", zap.Error(err))
+		return err
+	}
+	if err := req.check(planningId); err != nil {
+		return err
+	}
+
+	duration, err := planningsvc.TimerDuration(req.Seconds)
+	if err != nil {
+		return err
+	}
+	if _, err := h.planningSvc.StartTimer(planningId, playerId, duration, req.AutoReveal); err != nil {
+		h.logger.Error("failed to start timer", zap.Error(err))
+		return err
 	}
+	return nil
 }
@@ -0,0 +1,750 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"planning-poker/application/planningsvc"
+	"planning-poker/domain/planning"
+	"planning-poker/infra/in_memory"
+	"planning-poker/infra/redis"
+)
+
+func newTestServer(t testing.TB, opts ...planningsvc.Option) *httptest.Server {
+	svc := planningsvc.NewPlanningService(in_memory.NewPlanningRepository(), opts...)
+	srv := httptest.NewServer(NewWebsocketHandler(svc))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dialWithoutHandshake connects to the server with the given query, leaving the handshake to the test
+func dialWithoutHandshake(t testing.TB, srv *httptest.Server, query string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+query, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// dial connects with the current protocol version and reads the hello reply of the server
+func dial(t testing.TB, srv *httptest.Server) *websocket.Conn {
+	conn := dialWithoutHandshake(t, srv, fmt.Sprintf("?version=%d", ProtocolVersion))
+	require.Equal(t, "hello", readEvent(t, conn).Type)
+	return conn
+}
+
+func sendEvent(t testing.TB, conn *websocket.Conn, eventType string, payload interface{}) {
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": eventType, "payload": payload}))
+}
+
+// readEvent reads the next message and checks that it matches the protocol schema
+func readEvent(t testing.TB, conn *websocket.Conn) Message {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	validateOutbound(t, msg)
+	var event Message
+	require.NoError(t, json.Unmarshal(msg, &event))
+	return event
+}
+
+func readPlanning(t testing.TB, conn *websocket.Conn, eventType string) planning.Planning {
+	event := readEvent(t, conn)
+	require.Equal(t, eventType, event.Type)
+	var p planning.Planning
+	require.NoError(t, json.Unmarshal(event.Payload, &p))
+	return p
+}
+
+type joinedReply struct {
+	PlanningId string `json:"planningId"`
+	PlayerId   string `json:"playerId"`
+	Token      string `json:"token"`
+	MyVote     string `json:"myVote"`
+}
+
+func readJoined(t testing.TB, conn *websocket.Conn) joinedReply {
+	event := readEvent(t, conn)
+	require.Equal(t, "joined", event.Type)
+	var reply joinedReply
+	require.NoError(t, json.Unmarshal(event.Payload, &reply))
+	return reply
+}
+
+// createPlanning creates a planning and reads the replies of the owner connection
+func createPlanning(t testing.TB, conn *websocket.Conn, name string) planning.Planning {
+	sendEvent(t, conn, "create", map[string]interface{}{"owner": map[string]string{"name": name}})
+	readJoined(t, conn)
+	return readPlanning(t, conn, "create")
+}
+
+// joinPlanning joins a planning and reads the replies of the joining connection
+func joinPlanning(t *testing.T, conn *websocket.Conn, planningId string, name string) (planning.Planning, joinedReply) {
+	sendEvent(t, conn, "join", map[string]interface{}{"planningId": planningId, "player": map[string]string{"name": name}})
+	joined := readJoined(t, conn)
+	return readPlanning(t, conn, "join"), joined
+}
+
+func assertNoEvent(t *testing.T, conn *websocket.Conn) {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, _, err := conn.ReadMessage()
+	assert.Error(t, err, "expected no event")
+}
+
+func playerIdByName(p planning.Planning, name string) string {
+	for _, player := range p.Players {
+		if player.Name == name {
+			return player.Id
+		}
+	}
+	return ""
+}
+
+func TestWebsocketHandler_InvalidVoteOnlyRepliesToSender(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+
+	p, _ = joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+
+	sendEvent(t, guest, "vote", map[string]string{"planningId": p.Id, "playerId": playerIdByName(p, "guest"), "value": "XL"})
+
+	event := readEvent(t, guest)
+	assert.Equal(t, "error", event.Type)
+	var domainErr planning.Error
+	require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+	assert.Equal(t, planning.ErrInvalidVote.Code, domainErr.Code)
+	assertNoEvent(t, owner)
+}
+
+func sendRequest(t testing.TB, conn *websocket.Conn, eventType string, requestId string, payload interface{}) {
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": eventType, "requestId": requestId, "payload": payload}))
+}
+
+func TestWebsocketHandler_AcksRequestWithId(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	createPlanning(t, owner, "owner")
+
+	sendRequest(t, owner, "vote", "req-1", map[string]string{"value": "5"})
+
+	readPlanning(t, owner, "vote")
+	event := readEvent(t, owner)
+	assert.Equal(t, "ack", event.Type)
+	assert.Equal(t, "req-1", event.RequestId)
+	var ack AckReply
+	require.NoError(t, json.Unmarshal(event.Payload, &ack))
+	assert.Equal(t, "vote", ack.Type)
+}
+
+func TestWebsocketHandler_ErrorEchoesRequestId(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+	p := createPlanning(t, owner, "owner")
+	joinPlanning(t, guest, p.Id, "guest")
+
+	sendRequest(t, guest, "reveal", "req-2", map[string]string{})
+	sendRequest(t, guest, "vote", "req-3", "5")
+
+	for _, want := range []struct{ requestId, code string }{{"req-2", planning.ErrForbidden.Code}, {"req-3", errBadRequest.Code}} {
+		event := readEvent(t, guest)
+		require.Equal(t, "error", event.Type)
+		assert.Equal(t, want.requestId, event.RequestId)
+		var domainErr planning.Error
+		require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+		assert.Equal(t, want.code, domainErr.Code)
+	}
+}
+
+func TestWebsocketHandler_VoteAfterRevealIsRejected(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	createPlanning(t, owner, "owner")
+	sendEvent(t, owner, "vote", map[string]string{"value": "5"})
+	readPlanning(t, owner, "vote")
+	sendEvent(t, owner, "reveal", map[string]string{})
+	readPlanning(t, owner, "reveal")
+
+	sendRequest(t, owner, "vote", "req-4", map[string]string{"value": "8"})
+
+	event := readEvent(t, owner)
+	require.Equal(t, "error", event.Type)
+	assert.Equal(t, "req-4", event.RequestId)
+	var domainErr planning.Error
+	require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+	assert.Equal(t, planning.ErrRoundRevealed.Code, domainErr.Code)
+	assertNoEvent(t, owner)
+}
+
+func TestWebsocketHandler_OnlyOwnerCanClose(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+
+	joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+
+	sendEvent(t, guest, "close", map[string]string{"planningId": p.Id})
+
+	event := readEvent(t, guest)
+	assert.Equal(t, "error", event.Type)
+	var domainErr planning.Error
+	require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+	assert.Equal(t, planning.ErrForbidden.Code, domainErr.Code)
+	assertNoEvent(t, owner)
+}
+
+func TestWebsocketHandler_VoteUsesConnectionPlayer(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+	ownerId := playerIdByName(p, "owner")
+
+	p, _ = joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+	guestId := playerIdByName(p, "guest")
+
+	sendEvent(t, guest, "vote", map[string]string{"planningId": p.Id, "playerId": ownerId, "value": "8"})
+	readPlanning(t, guest, "vote")
+	readPlanning(t, owner, "vote")
+
+	sendEvent(t, owner, "reveal", map[string]string{"planningId": p.Id})
+	p = readPlanning(t, owner, "reveal")
+
+	assert.Equal(t, map[string]string{guestId: "8"}, p.Votes)
+}
+
+func TestWebsocketHandler_RejectsCommandsForOtherPlanning(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	other := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+
+	createPlanning(t, other, "other")
+
+	sendEvent(t, other, "close", map[string]string{"planningId": p.Id})
+
+	event := readEvent(t, other)
+	assert.Equal(t, "error", event.Type)
+	var domainErr planning.Error
+	require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+	assert.Equal(t, errWrongPlanning.Code, domainErr.Code)
+	assertNoEvent(t, owner)
+}
+
+func TestWebsocketHandler_RejectsCommandsBeforeJoin(t *testing.T) {
+	srv := newTestServer(t)
+	conn := dial(t, srv)
+
+	sendEvent(t, conn, "reveal", map[string]string{})
+
+	event := readEvent(t, conn)
+	assert.Equal(t, "error", event.Type)
+	var domainErr planning.Error
+	require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+	assert.Equal(t, errNotJoined.Code, domainErr.Code)
+}
+
+func TestWebsocketHandler_ResumeKeepsPlayerAndVote(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+	_, joined := joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+
+	sendEvent(t, guest, "vote", map[string]string{"value": "5"})
+	readPlanning(t, guest, "vote")
+	readPlanning(t, owner, "vote")
+	require.NoError(t, guest.Close())
+
+	reconnected := dial(t, srv)
+	sendEvent(t, reconnected, "resume", map[string]string{"token": joined.Token})
+
+	resumed := readJoined(t, reconnected)
+	assert.Equal(t, joined.PlayerId, resumed.PlayerId)
+	assert.Equal(t, "5", resumed.MyVote)
+	p = readPlanning(t, reconnected, "resume")
+	assert.Len(t, p.Players, 2)
+	assert.Contains(t, p.Votes, joined.PlayerId)
+}
+
+func TestWebsocketHandler_OnlyChangesAreBroadcast(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+	p := createPlanning(t, owner, "owner")
+	joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+
+	sendEvent(t, guest, "reveal", map[string]string{})
+	assert.Equal(t, "error", readEvent(t, guest).Type)
+	sendEvent(t, guest, "unknown", map[string]string{})
+	assert.Equal(t, "error", readEvent(t, guest).Type)
+	sendEvent(t, guest, "history", map[string]string{})
+	assert.Equal(t, "history", readEvent(t, guest).Type)
+
+	sendEvent(t, owner, "vote", map[string]string{"value": "3"})
+	readPlanning(t, owner, "vote")
+	readPlanning(t, guest, "vote")
+}
+
+func TestWebsocketHandler_ResumeWithInvalidToken(t *testing.T) {
+	srv := newTestServer(t)
+	conn := dial(t, srv)
+
+	sendEvent(t, conn, "resume", map[string]string{"token": "forged"})
+
+	event := readEvent(t, conn)
+	assert.Equal(t, "error", event.Type)
+	var domainErr planning.Error
+	require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+	assert.Equal(t, planning.ErrInvalidToken.Code, domainErr.Code)
+}
+
+func TestWebsocketHandler_PlayerLeavesAfterGracePeriod(t *testing.T) {
+	srv := newTestServer(t, planningsvc.WithGracePeriod(50*time.Millisecond))
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+	joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+	require.NoError(t, guest.Close())
+
+	p = readPlanning(t, owner, "player_left")
+	assert.Len(t, p.Players, 1)
+}
+
+func TestWebsocketHandler_ObserverIsListedSeparatelyAndCannotVote(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	observer := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+	sendEvent(t, observer, "join", map[string]interface{}{"planningId": p.Id, "player": map[string]string{"name": "po", "role": "observer"}})
+	readJoined(t, observer)
+	readEvent(t, observer)
+
+	event := readEvent(t, owner)
+	require.Equal(t, "join", event.Type)
+	var view struct {
+		Players   []planning.Player `json:"players"`
+		Observers []planning.Player `json:"observers"`
+	}
+	require.NoError(t, json.Unmarshal(event.Payload, &view))
+	assert.Len(t, view.Players, 1)
+	require.Len(t, view.Observers, 1)
+	assert.Equal(t, "po", view.Observers[0].Name)
+
+	sendEvent(t, observer, "vote", map[string]string{"value": "5"})
+	event = readEvent(t, observer)
+	assert.Equal(t, "error", event.Type)
+	var domainErr planning.Error
+	require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+	assert.Equal(t, planning.ErrCannotVote.Code, domainErr.Code)
+}
+
+func TestWebsocketHandler_RevealRecordsRoundOnCurrentStory(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+	ownerId := playerIdByName(p, "owner")
+
+	sendEvent(t, owner, "add_story", map[string]interface{}{"story": map[string]string{"title": "Login", "externalKey": "PROJ-1"}})
+	p = readPlanning(t, owner, "add_story")
+	require.Len(t, p.Stories, 1)
+	assert.Equal(t, p.Stories[0].Id, p.CurrentStoryId)
+
+	sendEvent(t, owner, "vote", map[string]string{"value": "3"})
+	readPlanning(t, owner, "vote")
+	sendEvent(t, owner, "reveal", map[string]string{})
+	readPlanning(t, owner, "reveal")
+	sendEvent(t, owner, "set_estimate", map[string]string{"estimate": "3"})
+	readPlanning(t, owner, "set_estimate")
+
+	sendEvent(t, owner, "history", map[string]string{})
+	event := readEvent(t, owner)
+	require.Equal(t, "history", event.Type)
+	var stories []planning.Story
+	require.NoError(t, json.Unmarshal(event.Payload, &stories))
+	require.Len(t, stories, 1)
+	require.Len(t, stories[0].Rounds, 1)
+	assert.Equal(t, map[string]string{ownerId: "3"}, stories[0].Rounds[0].Votes)
+	assert.Equal(t, "3", stories[0].Rounds[0].Estimate)
+	assert.False(t, stories[0].Rounds[0].RevealedAt.IsZero())
+}
+
+func TestWebsocketHandler_AutoRevealWhenEveryoneVoted(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+
+	sendEvent(t, owner, "create", map[string]interface{}{
+		"owner":    map[string]string{"name": "owner"},
+		"settings": map[string]bool{"autoReveal": true},
+	})
+	readJoined(t, owner)
+	p := readPlanning(t, owner, "create")
+	joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+
+	sendEvent(t, owner, "vote", map[string]string{"value": "3"})
+	readPlanning(t, owner, "vote")
+	readPlanning(t, guest, "vote")
+
+	sendEvent(t, guest, "vote", map[string]string{"value": "5"})
+	readPlanning(t, owner, "vote")
+	p = readPlanning(t, owner, "reveal")
+	assert.True(t, p.Revealed)
+	assert.Len(t, p.Votes, 2)
+	require.NotNil(t, p.Stats)
+	assert.Equal(t, 4.0, *p.Stats.Average)
+}
+
+func TestWebsocketHandler_TimerExpiresAndReveals(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+	joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+
+	sendEvent(t, owner, "start_timer", map[string]interface{}{"seconds": 1, "autoReveal": true})
+	p = readPlanning(t, guest, "timer_started")
+	require.NotNil(t, p.Timer)
+	assert.Greater(t, p.Timer.RemainingSeconds, 0.0)
+	readPlanning(t, owner, "timer_started")
+
+	p = readPlanning(t, guest, "reveal")
+	assert.True(t, p.Revealed)
+	p = readPlanning(t, guest, "timer_expired")
+	assert.True(t, p.Revealed)
+	assert.Nil(t, p.Timer)
+}
+
+func TestWebsocketHandler_OnlyOwnerCanStartTimer(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+
+	p := createPlanning(t, owner, "owner")
+	joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+
+	sendEvent(t, guest, "start_timer", map[string]interface{}{"seconds": 60})
+
+	event := readEvent(t, guest)
+	assert.Equal(t, "error", event.Type)
+	assertNoEvent(t, owner)
+}
+
+func TestWebsocketHandler_StartTimerOverflowingDuration(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+
+	createPlanning(t, owner, "owner")
+
+	// Converted to nanoseconds these seconds overflow to about 1.3 seconds
+	sendEvent(t, owner, "start_timer", map[string]interface{}{"seconds": 18446744075})
+
+	event := readEvent(t, owner)
+	assert.Equal(t, "error", event.Type)
+	var domainErr planning.Error
+	require.NoError(t, json.Unmarshal(event.Payload, &domainErr))
+	assert.Equal(t, planning.ErrInvalidTimer.Code, domainErr.Code)
+}
+
+// BenchmarkWebsocketHandler_VoteIn1000Sessions votes concurrently in 1000 sessions with one connection each
+func BenchmarkWebsocketHandler_VoteIn1000Sessions(b *testing.B) {
+	const sessions = 1000
+	srv := newTestServer(b)
+	conns := make([]*websocket.Conn, sessions)
+	for i := range conns {
+		conns[i] = dial(b, srv)
+		createPlanning(b, conns[i], "owner")
+		require.NoError(b, conns[i].SetReadDeadline(time.Time{}))
+	}
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := i; n < b.N; n += sessions {
+				if err := conn.WriteJSON(map[string]interface{}{"type": "vote", "payload": map[string]string{"value": "5"}}); err != nil {
+					b.Error(err)
+					return
+				}
+				var event Message
+				if err := conn.ReadJSON(&event); err != nil || event.Type != "vote" {
+					b.Error("expected vote broadcast", event.Type, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWebsocketHandler_DisconnectsSlowClient(t *testing.T) {
+	svc := planningsvc.NewPlanningService(in_memory.NewPlanningRepository(), planningsvc.WithGracePeriod(50*time.Millisecond))
+	handler := NewWebsocketHandler(svc, WithSendQueueSize(4), WithWriteTimeout(time.Second))
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	// The owner has no connection, so only the slow client receives the broadcasts
+	p := planning.Planning{Owner: planning.Player{Name: "owner"}}
+	require.NoError(t, svc.Create(&p))
+	slow := dial(t, srv)
+	joinPlanning(t, slow, p.Id, "slow")
+
+	// The slow client stops reading, so its socket buffer and then its queue fill up
+	big := strings.Repeat("x", 1<<20)
+	for range 64 {
+		handler.hub.broadcast(p.Id, "flood", big)
+	}
+
+	assert.Eventually(t, func() bool {
+		p, err := svc.GetById(p.Id, "")
+		return err == nil && len(p.Players) == 1 && p.Players[0].Name == "owner"
+	}, 5*time.Second, 20*time.Millisecond, "slow client should leave the planning")
+	handler.hub.mu.Lock()
+	_, ok := handler.hub.rooms[p.Id]
+	handler.hub.mu.Unlock()
+	assert.False(t, ok, "slow client should be removed from the hub")
+}
+
+// newHandlerWithOwner starts a server whose planning has an owner without a connection,
+// so only the connections of the test receive events
+func newHandlerWithOwner(t *testing.T, opts ...Option) (*httptest.Server, *planningsvc.PlanningService, planning.Planning) {
+	svc := planningsvc.NewPlanningService(in_memory.NewPlanningRepository(), planningsvc.WithGracePeriod(50*time.Millisecond))
+	srv := httptest.NewServer(NewWebsocketHandler(svc, opts...))
+	t.Cleanup(srv.Close)
+	p := planning.Planning{Owner: planning.Player{Name: "owner"}}
+	require.NoError(t, svc.Create(&p))
+	return srv, svc, p
+}
+
+func TestWebsocketHandler_DropsConnectionWithoutPong(t *testing.T) {
+	srv, svc, p := newHandlerWithOwner(t, WithHeartbeat(20*time.Millisecond, 100*time.Millisecond))
+	silent := dial(t, srv)
+	joinPlanning(t, silent, p.Id, "silent")
+
+	// Pongs are only sent while reading, so the silent client stops answering pings from here on
+	assert.Eventually(t, func() bool {
+		p, err := svc.GetById(p.Id, "")
+		return err == nil && len(p.Players) == 1
+	}, 2*time.Second, 20*time.Millisecond, "silent client should leave the planning")
+}
+
+func TestWebsocketHandler_KeepsConnectionThatAnswersPings(t *testing.T) {
+	srv, svc, p := newHandlerWithOwner(t, WithHeartbeat(20*time.Millisecond, 100*time.Millisecond))
+	active := dial(t, srv)
+	joinPlanning(t, active, p.Id, "active")
+	require.NoError(t, active.SetReadDeadline(time.Time{}))
+	go func() {
+		for {
+			if _, _, err := active.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(400 * time.Millisecond)
+
+	got, err := svc.GetById(p.Id, "")
+	require.NoError(t, err)
+	assert.Len(t, got.Players, 2)
+}
+
+func TestWebsocketHandler_ClosesConnectionOnTooLargeMessage(t *testing.T) {
+	srv, _, p := newHandlerWithOwner(t, WithReadLimit(1024))
+	conn := dial(t, srv)
+	joinPlanning(t, conn, p.Id, "guest")
+
+	sendEvent(t, conn, "add_story", map[string]interface{}{"story": map[string]string{"title": strings.Repeat("x", 2048)}})
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "unexpected error %v", err)
+}
+
+type closedReply struct {
+	PlanningId string               `json:"planningId"`
+	Reason     planning.CloseReason `json:"reason"`
+}
+
+func readClosed(t *testing.T, conn *websocket.Conn) closedReply {
+	event := readEvent(t, conn)
+	require.Equal(t, "close", event.Type)
+	var reply closedReply
+	require.NoError(t, json.Unmarshal(event.Payload, &reply))
+	return reply
+}
+
+func TestWebsocketHandler_CloseIsBroadcast(t *testing.T) {
+	srv := newTestServer(t)
+	owner := dial(t, srv)
+	guest := dial(t, srv)
+	p := createPlanning(t, owner, "owner")
+	joinPlanning(t, guest, p.Id, "guest")
+	readPlanning(t, owner, "join")
+
+	sendEvent(t, owner, "close", map[string]string{"planningId": p.Id})
+
+	assert.Equal(t, closedReply{PlanningId: p.Id, Reason: planning.ClosedByModerator}, readClosed(t, owner))
+	assert.Equal(t, closedReply{PlanningId: p.Id, Reason: planning.ClosedByModerator}, readClosed(t, guest))
+}
+
+func TestWebsocketHandler_ExpiredPlanningIsClosed(t *testing.T) {
+	svc := planningsvc.NewPlanningService(in_memory.NewPlanningRepository())
+	srv := httptest.NewServer(NewWebsocketHandler(svc))
+	t.Cleanup(srv.Close)
+	conn := dial(t, srv)
+	p := createPlanning(t, conn, "owner")
+
+	stop := svc.StartReaper(10*time.Millisecond, 10*time.Millisecond)
+	defer stop()
+
+	assert.Equal(t, closedReply{PlanningId: p.Id, Reason: planning.ClosedExpired}, readClosed(t, conn))
+	_, err := svc.GetById(p.Id, "")
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+}
+
+func TestWebsocketHandler_ActivePlanningIsNotExpired(t *testing.T) {
+	svc := planningsvc.NewPlanningService(in_memory.NewPlanningRepository())
+	srv := httptest.NewServer(NewWebsocketHandler(svc))
+	t.Cleanup(srv.Close)
+	conn := dial(t, srv)
+	p := createPlanning(t, conn, "owner")
+
+	// Nobody connects or disconnects for longer than the TTL, but the planning keeps changing
+	stop := svc.StartReaper(100*time.Millisecond, 10*time.Millisecond)
+	defer stop()
+	for _, value := range []string{"1", "2", "3", "5", "8", "13"} {
+		time.Sleep(40 * time.Millisecond)
+		sendEvent(t, conn, "vote", map[string]string{"value": value})
+		readPlanning(t, conn, "vote")
+	}
+
+	_, err := svc.GetById(p.Id, "")
+	assert.NoError(t, err)
+}
+
+func TestHub_StartStats(t *testing.T) {
+	svc := planningsvc.NewPlanningService(in_memory.NewPlanningRepository())
+	hub := NewHub(svc, NewLocalBroadcaster())
+	core, logs := observer.New(zap.InfoLevel)
+	hub.logger = zap.New(core)
+
+	stop := hub.StartStats(time.Millisecond)
+	require.Eventually(t, func() bool { return logs.FilterMessage("Active sessions").Len() > 0 }, time.Second, time.Millisecond)
+	stop()
+	time.Sleep(10 * time.Millisecond) // a tick running while stopping may still log
+	logged := logs.Len()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, logged, logs.Len(), "stats kept logging after they were stopped")
+}
+
+// awaitPlanning skips events until one of the given type arrives. Across instances a
+// connection may still receive broadcasts that were published before it joined.
+func awaitPlanning(t testing.TB, conn *websocket.Conn, eventType string) planning.Planning {
+	for {
+		event := readEvent(t, conn)
+		if event.Type == eventType {
+			var p planning.Planning
+			require.NoError(t, json.Unmarshal(event.Payload, &p))
+			return p
+		}
+	}
+}
+
+func TestWebsocketHandler_PlayersOnDifferentInstancesSeeEachOther(t *testing.T) {
+	repo := in_memory.NewPlanningRepository() // stands in for a database shared by the instances
+	redisServer := miniredis.RunT(t)
+	newInstance := func() *httptest.Server {
+		client := goredis.NewClient(&goredis.Options{Addr: redisServer.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		broadcaster, err := redis.NewBroadcaster(client)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = broadcaster.Close() })
+		svc := planningsvc.NewPlanningService(repo)
+		srv := httptest.NewServer(NewWebsocketHandler(svc, WithHub(NewHub(svc, broadcaster))))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	owner := dial(t, newInstance())
+	guest := dial(t, newInstance())
+	p := createPlanning(t, owner, "owner")
+	joinPlanning(t, guest, p.Id, "guest")
+	awaitPlanning(t, owner, "join")
+
+	sendEvent(t, guest, "vote", map[string]string{"value": "5"})
+	awaitPlanning(t, guest, "vote")
+	p = awaitPlanning(t, owner, "vote")
+	assert.Len(t, p.Votes, 1)
+
+	sendEvent(t, owner, "reveal", map[string]string{})
+	awaitPlanning(t, owner, "reveal")
+	p = awaitPlanning(t, guest, "reveal")
+	assert.Equal(t, "5", p.Votes[playerIdByName(p, "guest")])
+}
+
+func TestWebsocketHandler_PlayerKeepsSeatWhenMovingToAnotherInstance(t *testing.T) {
+	repo := in_memory.NewPlanningRepository() // stands in for a database shared by the instances
+	newInstance := func() *httptest.Server {
+		svc := planningsvc.NewPlanningService(repo, planningsvc.WithGracePeriod(60*time.Millisecond), planningsvc.WithTokenSecret([]byte("shared")))
+		t.Cleanup(svc.StartHeartbeat())
+		srv := httptest.NewServer(NewWebsocketHandler(svc))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	first, second := newInstance(), newInstance()
+	owner := dial(t, first)
+	p := createPlanning(t, owner, "owner")
+	early := dial(t, first)
+	_, earlyJoined := joinPlanning(t, early, p.Id, "early")
+	late := dial(t, first)
+	_, lateJoined := joinPlanning(t, late, p.Id, "late")
+
+	// early connects to the second instance before leaving the first, late only after
+	resume := func(token string) {
+		conn := dial(t, second)
+		sendEvent(t, conn, "resume", map[string]string{"token": token})
+		readJoined(t, conn)
+	}
+	resume(earlyJoined.Token)
+	require.NoError(t, early.Close())
+	require.NoError(t, late.Close())
+	resume(lateJoined.Token)
+
+	time.Sleep(200 * time.Millisecond)
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.Len(t, got.Players, 3)
+}
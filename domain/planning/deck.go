@@ -0,0 +1,125 @@
+package planning
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type DeckType string
+
+const (
+	DeckFibonacci         DeckType = "fibonacci"
+	DeckModifiedFibonacci DeckType = "modified_fibonacci"
+	DeckTShirt            DeckType = "tshirt"
+	DeckPowersOfTwo       DeckType = "powers_of_two"
+	DeckCustom            DeckType = "custom"
+)
+
+type Deck struct {
+	Type  DeckType `json:"type"`
+	Cards []Card   `json:"cards"`
+}
+
+// Card is a single card of a deck. Players vote with the card ID, Value is the
+// numeric weight of the card and nil for cards like "?" or "☕".
+type Card struct {
+	Id    string   `json:"id"`
+	Value *float64 `json:"value,omitempty"`
+}
+
+// NewCard creates a card and derives its numeric value from the ID if possible
+func NewCard(id string) Card {
+	card := Card{Id: id}
+	if id == "½" {
+		value := 0.5
+		card.Value = &value
+	} else if value, err := strconv.ParseFloat(id, 64); err == nil {
+		card.Value = &value
+	}
+	return card
+}
+
+// NewDeck builds one of the predefined decks or a custom deck from the given cards.
+// An empty deck type falls back to the fibonacci deck.
+func NewDeck(deckType DeckType, cards []Card) (Deck, error) {
+	var ids []string
+	switch deckType {
+	case "", DeckFibonacci:
+		deckType = DeckFibonacci
+		ids = []string{"0", "1", "2", "3", "5", "8", "13", "21", "34", "55", "89"}
+	case DeckModifiedFibonacci:
+		ids = []string{"0", "½", "1", "2", "3", "5", "8", "13", "20", "40", "100", "?", "☕"}
+	case DeckTShirt:
+		ids = []string{"XS", "S", "M", "L", "XL", "XXL", "?", "☕"}
+	case DeckPowersOfTwo:
+		ids = []string{"0", "1", "2", "4", "8", "16", "32", "64"}
+	case DeckCustom:
+		return newCustomDeck(cards)
+	default:
+		return Deck{}, invalidDeck("unknown deck type")
+	}
+	deck := Deck{Type: deckType}
+	for _, id := range ids {
+		deck.Cards = append(deck.Cards, NewCard(id))
+	}
+	return deck, nil
+}
+
+const maxCustomCards = 50
+
+func newCustomDeck(cards []Card) (Deck, error) {
+	if len(cards) == 0 {
+		return Deck{}, invalidDeck("custom deck needs at least one card")
+	}
+	if len(cards) > maxCustomCards {
+		return Deck{}, invalidDeck("custom deck has too many cards")
+	}
+	deck := Deck{Type: DeckCustom}
+	seen := make(map[string]bool)
+	for _, c := range cards {
+		c.Id = strings.TrimSpace(c.Id)
+		if c.Id == "" {
+			return Deck{}, invalidDeck("custom deck contains a card without id")
+		}
+		if seen[c.Id] {
+			return Deck{}, invalidDeck("custom deck contains duplicate card " + c.Id)
+		}
+		seen[c.Id] = true
+		if c.Value == nil {
+			c = NewCard(c.Id)
+		}
+		deck.Cards = append(deck.Cards, c)
+	}
+	return deck, nil
+}
+
+func invalidDeck(message string) error {
+	return &Error{Code: ErrInvalidDeck.Code, Message: message}
+}
+
+// Abstains reports whether the card says the player doesn't estimate, like "?" or "☕"
+func (c Card) Abstains() bool {
+	return c.Id == "?" || c.Id == "☕"
+}
+
+// Card returns the card with the given ID
+func (d Deck) Card(id string) (Card, bool) {
+	for _, c := range d.Cards {
+		if c.Id == id {
+			return c, true
+		}
+	}
+	return Card{}, false
+}
+
+func (d Deck) clone() Deck {
+	c := Deck{Type: d.Type, Cards: slices.Clone(d.Cards)}
+	for i, card := range c.Cards {
+		if card.Value != nil {
+			value := *card.Value
+			c.Cards[i].Value = &value
+		}
+	}
+	return c
+}
@@ -0,0 +1,66 @@
+package planning
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDeck(t *testing.T) {
+	tests := []struct {
+		name     string
+		deckType DeckType
+		cards    []Card
+		wantType DeckType
+		wantIds  []string
+		wantErr  bool
+	}{
+		{name: "default", deckType: "", wantType: DeckFibonacci, wantIds: []string{"0", "1", "2", "3", "5", "8", "13", "21", "34", "55", "89"}},
+		{name: "modified fibonacci", deckType: DeckModifiedFibonacci, wantType: DeckModifiedFibonacci, wantIds: []string{"0", "½", "1", "2", "3", "5", "8", "13", "20", "40", "100", "?", "☕"}},
+		{name: "t-shirt", deckType: DeckTShirt, wantType: DeckTShirt, wantIds: []string{"XS", "S", "M", "L", "XL", "XXL", "?", "☕"}},
+		{name: "powers of two", deckType: DeckPowersOfTwo, wantType: DeckPowersOfTwo, wantIds: []string{"0", "1", "2", "4", "8", "16", "32", "64"}},
+		{name: "custom", deckType: DeckCustom, cards: []Card{{Id: " 1 "}, {Id: "big"}}, wantType: DeckCustom, wantIds: []string{"1", "big"}},
+		{name: "custom without cards", deckType: DeckCustom, wantErr: true},
+		{name: "custom with empty card", deckType: DeckCustom, cards: []Card{{Id: " "}}, wantErr: true},
+		{name: "custom with duplicates", deckType: DeckCustom, cards: []Card{{Id: "1"}, {Id: "1"}}, wantErr: true},
+		{name: "unknown", deckType: "tarot", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deck, err := NewDeck(tt.deckType, tt.cards)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantType, deck.Type)
+			var ids []string
+			for _, c := range deck.Cards {
+				ids = append(ids, c.Id)
+			}
+			assert.Equal(t, tt.wantIds, ids)
+		})
+	}
+}
+
+func TestNewCard(t *testing.T) {
+	half := NewCard("½")
+	assert.Equal(t, 0.5, *half.Value)
+
+	thirteen := NewCard("13")
+	assert.Equal(t, 13.0, *thirteen.Value)
+
+	coffee := NewCard("☕")
+	assert.Nil(t, coffee.Value)
+}
+
+func TestDeck_Card(t *testing.T) {
+	deck, _ := NewDeck(DeckTShirt, nil)
+
+	card, ok := deck.Card("M")
+	assert.True(t, ok)
+	assert.Equal(t, "M", card.Id)
+
+	_, ok = deck.Card("5")
+	assert.False(t, ok)
+}
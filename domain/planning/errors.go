@@ -0,0 +1,35 @@
+package planning
+
+// Error is a domain error with a machine-readable code that can be sent to clients
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports errors with the same code as equal, so detailed errors still match their sentinel
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrPlanningNotFound = &Error{Code: "planning_not_found", Message: "planning with this id does not exist"}
+	ErrPlanningExists   = &Error{Code: "planning_exists", Message: "planning with this id already exists"}
+	ErrUnknownPlayer    = &Error{Code: "unknown_player", Message: "player is not part of this planning"}
+	ErrInvalidVote      = &Error{Code: "invalid_vote", Message: "card is not part of the deck"}
+	ErrInvalidDeck      = &Error{Code: "invalid_deck", Message: "deck is invalid"}
+	ErrForbidden        = &Error{Code: "forbidden", Message: "only the owner of the planning may do this"}
+	ErrInvalidToken     = &Error{Code: "invalid_token", Message: "reconnect token is invalid"}
+	ErrInvalidRole      = &Error{Code: "invalid_role", Message: "role is invalid"}
+	ErrCannotVote       = &Error{Code: "cannot_vote", Message: "observers cannot vote"}
+	ErrInvalidStory     = &Error{Code: "invalid_story", Message: "story needs a title"}
+	ErrUnknownStory     = &Error{Code: "unknown_story", Message: "story is not part of this planning"}
+	ErrNoNextStory      = &Error{Code: "no_next_story", Message: "there is no story after the current one"}
+	ErrNoRound          = &Error{Code: "no_round", Message: "story has not been revealed yet"}
+	ErrRoundRevealed    = &Error{Code: "round_revealed", Message: "votes of this round are revealed already"}
+	ErrInvalidTimer     = &Error{Code: "invalid_timer", Message: "timer duration must be between 1 second and 1 hour"}
+)
@@ -0,0 +1,104 @@
+package planning
+
+// Event is a change of a planning that delivery adapters pass on to the players.
+// Events carry the planning as every player may see it, without anybody's hidden vote.
+type Event interface {
+	PlanningId() string
+}
+
+// Subscriber receives every event published by the planning service. Handle is called
+// synchronously by the goroutine that made the change, so it must not block.
+type Subscriber interface {
+	Handle(event Event)
+}
+
+// CloseReason tells why a planning was closed
+type CloseReason string
+
+const (
+	ClosedByModerator CloseReason = "closed"    // a moderator closed the planning
+	ClosedExpired     CloseReason = "expired"   // nobody connected for longer than the session TTL
+	ClosedAbandoned   CloseReason = "abandoned" // the last player left
+)
+
+type PlayerJoined struct {
+	Planning Planning
+	Player   Player
+}
+
+type PlayerLeft struct {
+	Planning Planning
+	PlayerId string
+}
+
+// OwnerChanged follows a PlayerLeft when the owner left and another player took over
+type OwnerChanged struct {
+	Planning Planning
+	Owner    Player
+}
+
+type RoleChanged struct {
+	Planning Planning
+	PlayerId string
+	Role     Role
+}
+
+// VoteCast tells that a player voted, the card stays hidden until the votes are revealed
+type VoteCast struct {
+	Planning Planning
+	PlayerId string
+}
+
+type VotesRevealed struct {
+	Planning Planning
+}
+
+type RoundReset struct {
+	Planning Planning
+}
+
+type StoryAdded struct {
+	Planning Planning
+	Story    Story
+}
+
+// CurrentStoryChanged starts a fresh round on another story
+type CurrentStoryChanged struct {
+	Planning Planning
+	StoryId  string
+}
+
+type EstimateSet struct {
+	Planning Planning
+	StoryId  string
+	Estimate string
+}
+
+type TimerStarted struct {
+	Planning Planning
+}
+
+// TimerExpired follows a VotesRevealed when the timer was started with auto reveal
+type TimerExpired struct {
+	Planning Planning
+}
+
+// PlanningClosed is published once the planning is deleted, so it only carries the ID
+type PlanningClosed struct {
+	Id     string
+	Reason CloseReason
+}
+
+func (e PlayerJoined) PlanningId() string        { return e.Planning.Id }
+func (e PlayerLeft) PlanningId() string          { return e.Planning.Id }
+func (e OwnerChanged) PlanningId() string        { return e.Planning.Id }
+func (e RoleChanged) PlanningId() string         { return e.Planning.Id }
+func (e VoteCast) PlanningId() string            { return e.Planning.Id }
+func (e VotesRevealed) PlanningId() string       { return e.Planning.Id }
+func (e RoundReset) PlanningId() string          { return e.Planning.Id }
+func (e StoryAdded) PlanningId() string          { return e.Planning.Id }
+func (e CurrentStoryChanged) PlanningId() string { return e.Planning.Id }
+func (e EstimateSet) PlanningId() string         { return e.Planning.Id }
+func (e TimerStarted) PlanningId() string        { return e.Planning.Id }
+func (e TimerExpired) PlanningId() string        { return e.Planning.Id }
+func (e PlanningClosed) PlanningId() string      { return e.Id }
@@ -1,19 +1,144 @@
 package planning
 
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"time"
+)
+
 type Planning struct {
-	Id            string         `json:"id"`
-	LastConnected string         `json:"lastConnected"`
-	CreatedAt     string         `json:"created_at"`
-	Owner         Player         `json:"owner"`
-	Players       []Player       `json:"players"`
-	Revealed      bool           `json:"revealed"`
-	MyVote        int            `json:"myVote"` // My vote is the vote of the player who is currently connected
-	Votes         map[string]int `json:"votes"`  // Vote key is player ID
-	HiddenVotes   map[string]int `json:"-"`      // Vote key is player ID
+	Id             string            `json:"id"`
+	LastConnected  time.Time         `json:"lastConnected"` // LastConnected is the last time a player connected, disconnected or changed the planning
+	CreatedAt      time.Time         `json:"created_at"`
+	Owner          Player            `json:"owner"`
+	Players        []Player          `json:"players"`
+	Deck           Deck              `json:"deck"`
+	Settings       Settings          `json:"settings"`
+	Stories        []Story           `json:"stories"`
+	CurrentStoryId string            `json:"currentStoryId"`
+	Revealed       bool              `json:"revealed"`
+	MyVote         string            `json:"myVote"`          // My vote is the card ID of the player who is currently connected
+	Votes          map[string]string `json:"votes"`           // Vote key is player ID, value is the card ID once revealed
+	HiddenVotes    map[string]string `json:"-"`               // Vote key is player ID
+	Stats          *RoundStats       `json:"stats,omitempty"` // Stats are only set once the votes are revealed
+	Timer          *Timer            `json:"timer,omitempty"`
+	Version        int64             `json:"version"` // Version counts the changes, clients drop planning snapshots older than one they have seen
+}
+
+type Settings struct {
+	AutoReveal bool `json:"autoReveal"` // AutoReveal reveals the votes once every voter has voted
+}
+
+// Timer is a countdown for the current round that is kept by the server
+type Timer struct {
+	Deadline         time.Time `json:"deadline"`
+	AutoReveal       bool      `json:"autoReveal"`       // AutoReveal reveals the votes when the timer runs out
+	RemainingSeconds float64   `json:"remainingSeconds"` // RemainingSeconds is calculated when the planning is read
 }
 
+type Role string
+
+const (
+	RoleVoter       Role = "voter"
+	RoleObserver    Role = "observer"    // Observers watch the planning without voting
+	RoleFacilitator Role = "facilitator" // Facilitators vote and may moderate like the owner
+)
+
 type Player struct {
-	Id      string `json:"id"`
-	Name    string `json:"name"`
-	IsOwner bool   `json:"-"` // IsOwner indicates if the player is the owner of the planning
+	Id       string    `json:"id"`
+	Name     string    `json:"name"`
+	Role     Role      `json:"role"`
+	IsOwner  bool      `json:"-"` // IsOwner indicates if the player is the owner of the planning
+	LastSeen time.Time `json:"-"` // LastSeen is the last time the player was known to be connected, to any instance
+}
+
+// CanVote reports whether the player takes part in the estimation
+func (p Player) CanVote() bool {
+	return p.Role != RoleObserver
+}
+
+// MarshalJSON lists observers separately from the players that vote
+func (p Planning) MarshalJSON() ([]byte, error) {
+	type planningJSON Planning
+	voters := make([]Player, 0, len(p.Players))
+	observers := make([]Player, 0)
+	for _, player := range p.Players {
+		if player.CanVote() {
+			voters = append(voters, player)
+		} else {
+			observers = append(observers, player)
+		}
+	}
+	return json.Marshal(struct {
+		planningJSON
+		Players   []Player `json:"players"`
+		Observers []Player `json:"observers"`
+	}{
+		planningJSON: planningJSON(p),
+		Players:      voters,
+		Observers:    observers,
+	})
+}
+
+// Player returns the player with the given ID
+func (p Planning) Player(playerId string) (Player, bool) {
+	for _, player := range p.Players {
+		if player.Id == playerId {
+			return player, true
+		}
+	}
+	return Player{}, false
+}
+
+// EveryoneVoted reports whether every player that can vote has voted in the current round
+func (p Planning) EveryoneVoted() bool {
+	voters := 0
+	for _, player := range p.Players {
+		if !player.CanVote() {
+			continue
+		}
+		voters++
+		if _, ok := p.HiddenVotes[player.Id]; !ok {
+			return false
+		}
+	}
+	return voters > 0
+}
+
+// CanModerate reports whether the player may reveal, reset or close the planning
+func (p Planning) CanModerate(playerId string) bool {
+	if playerId == "" {
+		return false
+	}
+	if p.Owner.Id == playerId {
+		return true
+	}
+	player, ok := p.Player(playerId)
+	return ok && player.Role == RoleFacilitator
+}
+
+// Clone returns a deep copy of the planning that shares no maps, slices or pointers with p.
+// Repositories hand out clones so callers can't change the stored state without holding a lock.
+func (p Planning) Clone() Planning {
+	c := p
+	c.Players = slices.Clone(p.Players)
+	c.Deck = p.Deck.clone()
+	if p.Stories != nil {
+		c.Stories = make([]Story, len(p.Stories))
+		for i, story := range p.Stories {
+			c.Stories[i] = story.clone()
+		}
+	}
+	c.Votes = maps.Clone(p.Votes)
+	c.HiddenVotes = maps.Clone(p.HiddenVotes)
+	if p.Stats != nil {
+		stats := p.Stats.clone()
+		c.Stats = &stats
+	}
+	if p.Timer != nil {
+		timer := *p.Timer
+		c.Timer = &timer
+	}
+	return c
 }
@@ -0,0 +1,109 @@
+package planning
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanning_MarshalJSONSeparatesObservers(t *testing.T) {
+	p := Planning{
+		Id: "planning1",
+		Players: []Player{
+			{Id: "voter", Role: RoleVoter},
+			{Id: "observer", Role: RoleObserver},
+			{Id: "facilitator", Role: RoleFacilitator},
+		},
+	}
+
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+
+	var out struct {
+		Id        string   `json:"id"`
+		Players   []Player `json:"players"`
+		Observers []Player `json:"observers"`
+	}
+	require.NoError(t, json.Unmarshal(data, &out))
+	assert.Equal(t, "planning1", out.Id)
+	assert.Equal(t, []Player{{Id: "voter", Role: RoleVoter}, {Id: "facilitator", Role: RoleFacilitator}}, out.Players)
+	assert.Equal(t, []Player{{Id: "observer", Role: RoleObserver}}, out.Observers)
+}
+
+func TestPlanning_CanModerate(t *testing.T) {
+	p := Planning{
+		Owner: Player{Id: "owner"},
+		Players: []Player{
+			{Id: "owner", Role: RoleVoter},
+			{Id: "voter", Role: RoleVoter},
+			{Id: "facilitator", Role: RoleFacilitator},
+		},
+	}
+
+	assert.True(t, p.CanModerate("owner"))
+	assert.True(t, p.CanModerate("facilitator"))
+	assert.False(t, p.CanModerate("voter"))
+	assert.False(t, p.CanModerate("stranger"))
+	assert.False(t, p.CanModerate(""))
+}
+
+func TestPlanning_EveryoneVoted(t *testing.T) {
+	players := []Player{{Id: "a"}, {Id: "b", Role: RoleFacilitator}, {Id: "observer", Role: RoleObserver}}
+
+	tests := []struct {
+		name    string
+		players []Player
+		votes   map[string]string
+		want    bool
+	}{
+		{name: "all voters voted", players: players, votes: map[string]string{"a": "1", "b": "2"}, want: true},
+		{name: "voter missing", players: players, votes: map[string]string{"a": "1"}, want: false},
+		{name: "no voters", players: []Player{{Id: "observer", Role: RoleObserver}}, votes: map[string]string{}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Planning{Players: tt.players, HiddenVotes: tt.votes}
+			assert.Equal(t, tt.want, p.EveryoneVoted())
+		})
+	}
+}
+
+func TestPlanning_CloneSharesNoState(t *testing.T) {
+	deck, err := NewDeck(DeckFibonacci, nil)
+	require.NoError(t, err)
+	votes := map[string]string{"voter": "5"}
+	stats := CalculateStats(deck, votes)
+	original := Planning{
+		Id:          "planning1",
+		Players:     []Player{{Id: "voter", Role: RoleVoter}},
+		Deck:        deck,
+		Stories:     []Story{{Id: "story1", Rounds: []Round{{Votes: map[string]string{"voter": "5"}}}}},
+		Revealed:    true,
+		Votes:       map[string]string{"voter": "5"},
+		HiddenVotes: map[string]string{"voter": "5"},
+		Stats:       &stats,
+		Timer:       &Timer{AutoReveal: true},
+	}
+	clone := original.Clone()
+	require.Equal(t, original, clone)
+
+	clone.Players[0].Role = RoleObserver
+	*clone.Deck.Cards[1].Value = 42
+	clone.Stories[0].Rounds[0].Votes["voter"] = "8"
+	clone.Votes["voter"] = "8"
+	clone.HiddenVotes["other"] = "1"
+	*clone.Stats.Average = 42
+	clone.Stats.Mode[0] = "8"
+	clone.Timer.AutoReveal = false
+
+	assert.Equal(t, RoleVoter, original.Players[0].Role)
+	assert.Equal(t, 1.0, *original.Deck.Cards[1].Value)
+	assert.Equal(t, "5", original.Stories[0].Rounds[0].Votes["voter"])
+	assert.Equal(t, map[string]string{"voter": "5"}, original.Votes)
+	assert.Equal(t, map[string]string{"voter": "5"}, original.HiddenVotes)
+	assert.Equal(t, 5.0, *original.Stats.Average)
+	assert.Equal(t, []string{"5"}, original.Stats.Mode)
+	assert.True(t, original.Timer.AutoReveal)
+}
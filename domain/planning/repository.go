@@ -1,12 +1,26 @@
 package planning
 
+import "time"
+
+// Repository stores the plannings. Revealing, resetting and moving to another story end the
+// round, which stops its timer. RevealVotes reports whether the call revealed the round, so
+// of concurrent reveals only one is announced.
 type Repository interface {
 	Create(planning Planning) error
 	GetById(id string) (Planning, error)
+	GetAll() ([]Planning, error)
 	Join(planningId string, player Player) (Planning, error)
 	Leave(planningId string, playerId string) (Planning, error)
-	Vote(planningId string, playerId string, value int) error
-	RevealVotes(planningId string) (Planning, error)
+	SetRole(planningId string, playerId string, role Role) (Planning, error)
+	Vote(planningId string, vote Vote) error
+	RevealVotes(planningId string, revealedAt time.Time) (Planning, bool, error)
 	ResetVotes(planningId string) error
+	AddStory(planningId string, story Story) (Planning, error)
+	SetCurrentStory(planningId string, storyId string) (Planning, error)
+	SetEstimate(planningId string, storyId string, estimate string) (Planning, error)
+	SetTimer(planningId string, timer *Timer) (Planning, error)
+	Touch(planningId string, at time.Time) error
+	TouchPlayer(planningId string, playerId string, at time.Time) error
+	DeleteIdle(idleSince time.Time) ([]string, error)
 	Close(planningId string)
 }
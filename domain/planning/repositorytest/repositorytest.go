@@ -0,0 +1,623 @@
+// Package repositorytest contains the contract tests every planning.Repository implementation has to pass.
+package repositorytest
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"planning-poker/domain/planning"
+)
+
+// Factory returns an empty repository for a single test
+type Factory func(t *testing.T) planning.Repository
+
+// Run runs the whole contract test suite against the repositories created by newRepo
+func Run(t *testing.T, newRepo Factory) {
+	t.Run("CreateAndGetById", func(t *testing.T) { testCreateAndGetById(t, newRepo(t)) })
+	t.Run("CreateDuplicate", func(t *testing.T) { testCreateDuplicate(t, newRepo(t)) })
+	t.Run("GetByIdUnknown", func(t *testing.T) { testGetByIdUnknown(t, newRepo(t)) })
+	t.Run("GetAll", func(t *testing.T) { testGetAll(t, newRepo(t)) })
+	t.Run("Join", func(t *testing.T) { testJoin(t, newRepo(t)) })
+	t.Run("Leave", func(t *testing.T) { testLeave(t, newRepo(t)) })
+	t.Run("LeaveLastPlayerDeletesPlanning", func(t *testing.T) { testLeaveLastPlayerDeletesPlanning(t, newRepo(t)) })
+	t.Run("LeaveOwnerHandsOverOwnership", func(t *testing.T) { testLeaveOwnerHandsOverOwnership(t, newRepo(t)) })
+	t.Run("LeaveOwnerPrefersVotersOverObservers", func(t *testing.T) { testLeaveOwnerPrefersVotersOverObservers(t, newRepo(t)) })
+	t.Run("SetRole", func(t *testing.T) { testSetRole(t, newRepo(t)) })
+	t.Run("SetRoleOfOwner", func(t *testing.T) { testSetRoleOfOwner(t, newRepo(t)) })
+	t.Run("VotesHiddenUntilReveal", func(t *testing.T) { testVotesHiddenUntilReveal(t, newRepo(t)) })
+	t.Run("VoteAndReveal", func(t *testing.T) { testVoteAndReveal(t, newRepo(t)) })
+	t.Run("VoteAfterRevealIsRejected", func(t *testing.T) { testVoteAfterRevealIsRejected(t, newRepo(t)) })
+	t.Run("VoteUnknownPlanning", func(t *testing.T) { testVoteUnknownPlanning(t, newRepo(t)) })
+	t.Run("ResetVotes", func(t *testing.T) { testResetVotes(t, newRepo(t)) })
+	t.Run("Stories", func(t *testing.T) { testStories(t, newRepo(t)) })
+	t.Run("Timer", func(t *testing.T) { testTimer(t, newRepo(t)) })
+	t.Run("EndOfRoundStopsTimer", func(t *testing.T) { testEndOfRoundStopsTimer(t, newRepo(t)) })
+	t.Run("Close", func(t *testing.T) { testClose(t, newRepo(t)) })
+	t.Run("DeleteIdle", func(t *testing.T) { testDeleteIdle(t, newRepo(t)) })
+	t.Run("TouchPlayer", func(t *testing.T) { testTouchPlayer(t, newRepo(t)) })
+	t.Run("ChangesCountUpVersion", func(t *testing.T) { testChangesCountUpVersion(t, newRepo(t)) })
+	t.Run("ReturnedPlanningIsACopy", func(t *testing.T) { testReturnedPlanningIsACopy(t, newRepo(t)) })
+	t.Run("RevealedVotesAreNotSharedWithHiddenVotes", func(t *testing.T) { testRevealedVotesAreNotSharedWithHiddenVotes(t, newRepo(t)) })
+	t.Run("ConcurrentJoins", func(t *testing.T) { testConcurrentJoins(t, newRepo(t)) })
+	t.Run("ConcurrentVotes", func(t *testing.T) { testConcurrentVotes(t, newRepo(t)) })
+	t.Run("ConcurrentRevealRecordsOneRound", func(t *testing.T) { testConcurrentRevealRecordsOneRound(t, newRepo(t)) })
+	t.Run("ConcurrentReadsAndWrites", func(t *testing.T) { testConcurrentReadsAndWrites(t, newRepo(t)) })
+}
+
+// NewPlanning returns a planning with an owner that has not joined yet, like PlanningService.Create does
+func NewPlanning() planning.Planning {
+	deck, _ := planning.NewDeck(planning.DeckFibonacci, nil)
+	return planning.Planning{
+		Id:            uuid.NewString(),
+		Owner:         planning.Player{Id: uuid.NewString(), Name: "owner", Role: planning.RoleVoter, IsOwner: true},
+		Deck:          deck,
+		Votes:         make(map[string]string),
+		HiddenVotes:   make(map[string]string),
+		CreatedAt:     time.Now(),
+		LastConnected: time.Now(),
+	}
+}
+
+// CreateWithOwner creates a planning and lets its owner join
+func CreateWithOwner(t *testing.T, repo planning.Repository) planning.Planning {
+	t.Helper()
+	p := NewPlanning()
+	require.NoError(t, repo.Create(p))
+	p, err := repo.Join(p.Id, p.Owner)
+	require.NoError(t, err)
+	return p
+}
+
+// JoinPlayer lets a new voter join the planning
+func JoinPlayer(t *testing.T, repo planning.Repository, planningId string, name string) planning.Player {
+	t.Helper()
+	player := planning.Player{Id: uuid.NewString(), Name: name, Role: planning.RoleVoter}
+	_, err := repo.Join(planningId, player)
+	require.NoError(t, err)
+	return player
+}
+
+func testCreateAndGetById(t *testing.T, repo planning.Repository) {
+	p := NewPlanning()
+	require.NoError(t, repo.Create(p))
+
+	got, err := repo.GetById(p.Id)
+
+	require.NoError(t, err)
+	assert.Equal(t, p.Id, got.Id)
+	assert.Equal(t, p.Deck, got.Deck)
+}
+
+func testCreateDuplicate(t *testing.T, repo planning.Repository) {
+	p := NewPlanning()
+	require.NoError(t, repo.Create(p))
+
+	err := repo.Create(p)
+
+	assert.ErrorIs(t, err, planning.ErrPlanningExists)
+}
+
+func testGetByIdUnknown(t *testing.T, repo planning.Repository) {
+	_, err := repo.GetById(uuid.NewString())
+
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+}
+
+func testGetAll(t *testing.T, repo planning.Repository) {
+	first := CreateWithOwner(t, repo)
+	second := CreateWithOwner(t, repo)
+	closed := CreateWithOwner(t, repo)
+	repo.Close(closed.Id)
+
+	plans, err := repo.GetAll()
+
+	require.NoError(t, err)
+	ids := make([]string, 0, len(plans))
+	for _, p := range plans {
+		ids = append(ids, p.Id)
+	}
+	assert.ElementsMatch(t, []string{first.Id, second.Id}, ids)
+}
+
+func testJoin(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	player := JoinPlayer(t, repo, p.Id, "player")
+
+	got, err := repo.GetById(p.Id)
+
+	require.NoError(t, err)
+	assert.Equal(t, p.Owner.Id, got.Owner.Id)
+	require.Len(t, got.Players, 2)
+	assert.Equal(t, player.Id, got.Players[1].Id)
+	assert.Equal(t, planning.RoleVoter, got.Players[1].Role)
+
+	_, err = repo.Join(uuid.NewString(), player)
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+}
+
+func testLeave(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	player := JoinPlayer(t, repo, p.Id, "player")
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: player.Id, CardId: "3"}))
+
+	got, err := repo.Leave(p.Id, player.Id)
+
+	require.NoError(t, err)
+	require.Len(t, got.Players, 1)
+	assert.Equal(t, p.Owner.Id, got.Players[0].Id)
+	assert.NotContains(t, got.Votes, player.Id)
+	assert.NotContains(t, got.HiddenVotes, player.Id)
+
+	_, err = repo.Leave(uuid.NewString(), player.Id)
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+}
+
+func testLeaveLastPlayerDeletesPlanning(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+
+	got, err := repo.Leave(p.Id, p.Owner.Id)
+
+	require.NoError(t, err)
+	assert.Empty(t, got.Id)
+	_, err = repo.GetById(p.Id)
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+}
+
+func testLeaveOwnerHandsOverOwnership(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	first := JoinPlayer(t, repo, p.Id, "first")
+	JoinPlayer(t, repo, p.Id, "second")
+
+	got, err := repo.Leave(p.Id, p.Owner.Id)
+
+	require.NoError(t, err)
+	assert.Equal(t, first.Id, got.Owner.Id)
+	got, err = repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.Equal(t, first.Id, got.Owner.Id)
+	assert.True(t, got.CanModerate(first.Id))
+}
+
+func testLeaveOwnerPrefersVotersOverObservers(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	observer := JoinPlayer(t, repo, p.Id, "observer")
+	_, err := repo.SetRole(p.Id, observer.Id, planning.RoleObserver)
+	require.NoError(t, err)
+	voter := JoinPlayer(t, repo, p.Id, "voter")
+
+	got, err := repo.Leave(p.Id, p.Owner.Id)
+
+	require.NoError(t, err)
+	assert.Equal(t, voter.Id, got.Owner.Id)
+
+	// Observers only take over when nobody else is left
+	got, err = repo.Leave(p.Id, voter.Id)
+
+	require.NoError(t, err)
+	assert.Equal(t, observer.Id, got.Owner.Id)
+}
+
+func testSetRole(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	player := JoinPlayer(t, repo, p.Id, "player")
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: player.Id, CardId: "5"}))
+
+	got, err := repo.SetRole(p.Id, player.Id, planning.RoleObserver)
+
+	require.NoError(t, err)
+	observer, ok := got.Player(player.Id)
+	require.True(t, ok)
+	assert.Equal(t, planning.RoleObserver, observer.Role)
+	assert.NotContains(t, got.HiddenVotes, player.Id, "observers must not keep their vote")
+
+	_, err = repo.SetRole(p.Id, uuid.NewString(), planning.RoleVoter)
+	assert.ErrorIs(t, err, planning.ErrUnknownPlayer)
+}
+
+func testSetRoleOfOwner(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+
+	got, err := repo.SetRole(p.Id, p.Owner.Id, planning.RoleObserver)
+
+	require.NoError(t, err)
+	assert.Equal(t, planning.RoleObserver, got.Owner.Role)
+	got, err = repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.Equal(t, planning.RoleObserver, got.Owner.Role, "the owner must keep the role")
+}
+
+func testVotesHiddenUntilReveal(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	player := JoinPlayer(t, repo, p.Id, "player")
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "5"}))
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: player.Id, CardId: "8"}))
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: player.Id, CardId: "13"}))
+
+	got, err := repo.GetById(p.Id)
+
+	require.NoError(t, err)
+	assert.False(t, got.Revealed)
+	assert.Equal(t, map[string]string{p.Owner.Id: "", player.Id: ""}, got.Votes, "only who voted is visible")
+	assert.Equal(t, map[string]string{p.Owner.Id: "5", player.Id: "13"}, got.HiddenVotes)
+
+	_, _, err = repo.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+	got, err = repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.True(t, got.Revealed)
+	assert.Equal(t, map[string]string{p.Owner.Id: "5", player.Id: "13"}, got.Votes)
+}
+
+func testVoteAndReveal(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "5"}))
+
+	revealed, revealedNow, err := repo.RevealVotes(p.Id, time.Now())
+
+	require.NoError(t, err)
+	assert.True(t, revealedNow)
+	assert.True(t, revealed.Revealed)
+	assert.Equal(t, map[string]string{p.Owner.Id: "5"}, revealed.Votes)
+
+	again, revealedNow, err := repo.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+	assert.False(t, revealedNow, "revealing twice must report that the round was revealed already")
+	assert.True(t, again.Revealed)
+}
+
+func testVoteAfterRevealIsRejected(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "5"}))
+	_, _, err := repo.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+
+	err = repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "8"})
+
+	assert.ErrorIs(t, err, planning.ErrRoundRevealed)
+
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{p.Owner.Id: "5"}, got.Votes)
+}
+
+func testVoteUnknownPlanning(t *testing.T, repo planning.Repository) {
+	err := repo.Vote(uuid.NewString(), planning.Vote{PlayerId: uuid.NewString(), CardId: "5"})
+
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+}
+
+func testResetVotes(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "5"}))
+	_, _, err := repo.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+
+	require.NoError(t, repo.ResetVotes(p.Id))
+
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.False(t, got.Revealed)
+	assert.Empty(t, got.Votes)
+	assert.Empty(t, got.HiddenVotes)
+}
+
+func testStories(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	first := planning.Story{Id: uuid.NewString(), Title: "first", ExternalKey: "PROJ-1"}
+	second := planning.Story{Id: uuid.NewString(), Title: "second"}
+
+	_, err := repo.AddStory(p.Id, first)
+	require.NoError(t, err)
+	got, err := repo.AddStory(p.Id, second)
+	require.NoError(t, err)
+	assert.Equal(t, first.Id, got.CurrentStoryId)
+
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "8"}))
+	revealedAt := time.Now().UTC().Truncate(time.Millisecond)
+	_, _, err = repo.RevealVotes(p.Id, revealedAt)
+	require.NoError(t, err)
+	_, err = repo.SetEstimate(p.Id, first.Id, "8")
+	require.NoError(t, err)
+
+	got, err = repo.SetCurrentStory(p.Id, second.Id)
+	require.NoError(t, err)
+	assert.Equal(t, second.Id, got.CurrentStoryId)
+	assert.False(t, got.Revealed)
+	assert.Empty(t, got.HiddenVotes)
+
+	got, err = repo.GetById(p.Id)
+	require.NoError(t, err)
+	require.Len(t, got.Stories, 2)
+	require.Len(t, got.Stories[0].Rounds, 1)
+	round := got.Stories[0].Rounds[0]
+	assert.Equal(t, map[string]string{p.Owner.Id: "8"}, round.Votes)
+	assert.Equal(t, "8", round.Estimate)
+	assert.True(t, revealedAt.Equal(round.RevealedAt))
+	assert.Empty(t, got.Stories[1].Rounds)
+
+	_, err = repo.SetCurrentStory(p.Id, uuid.NewString())
+	assert.ErrorIs(t, err, planning.ErrUnknownStory)
+	_, err = repo.SetEstimate(p.Id, second.Id, "5")
+	assert.ErrorIs(t, err, planning.ErrNoRound)
+}
+
+func testTimer(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	deadline := time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)
+
+	_, err := repo.SetTimer(p.Id, &planning.Timer{Deadline: deadline, AutoReveal: true})
+	require.NoError(t, err)
+
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	require.NotNil(t, got.Timer)
+	assert.True(t, deadline.Equal(got.Timer.Deadline))
+	assert.True(t, got.Timer.AutoReveal)
+
+	got, err = repo.SetTimer(p.Id, nil)
+	require.NoError(t, err)
+	assert.Nil(t, got.Timer)
+}
+
+func testEndOfRoundStopsTimer(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	story := planning.Story{Id: uuid.NewString(), Title: "story"}
+	_, err := repo.AddStory(p.Id, story)
+	require.NoError(t, err)
+	timer := &planning.Timer{Deadline: time.Now().Add(time.Minute), AutoReveal: true}
+
+	_, err = repo.SetTimer(p.Id, timer)
+	require.NoError(t, err)
+	got, _, err := repo.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+	assert.Nil(t, got.Timer, "reveal")
+
+	_, err = repo.SetTimer(p.Id, timer)
+	require.NoError(t, err)
+	require.NoError(t, repo.ResetVotes(p.Id))
+	got, err = repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.Nil(t, got.Timer, "reset")
+
+	_, err = repo.SetTimer(p.Id, timer)
+	require.NoError(t, err)
+	got, err = repo.SetCurrentStory(p.Id, story.Id)
+	require.NoError(t, err)
+	assert.Nil(t, got.Timer, "story change")
+}
+
+func testClose(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+
+	repo.Close(p.Id)
+
+	_, err := repo.GetById(p.Id)
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+}
+
+func testDeleteIdle(t *testing.T, repo planning.Repository) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	idle := CreateWithOwner(t, repo)
+	active := CreateWithOwner(t, repo)
+	require.NoError(t, repo.Touch(idle.Id, now.Add(-2*time.Hour)))
+	require.NoError(t, repo.Touch(active.Id, now))
+
+	deleted, err := repo.DeleteIdle(now.Add(-time.Hour))
+
+	require.NoError(t, err)
+	assert.Contains(t, deleted, idle.Id)
+	assert.NotContains(t, deleted, active.Id)
+	_, err = repo.GetById(idle.Id)
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+	got, err := repo.GetById(active.Id)
+	require.NoError(t, err)
+	assert.True(t, now.Equal(got.LastConnected))
+
+	assert.ErrorIs(t, repo.Touch(idle.Id, now), planning.ErrPlanningNotFound)
+}
+
+func testTouchPlayer(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	require.NoError(t, repo.TouchPlayer(p.Id, p.Owner.Id, now))
+	require.NoError(t, repo.TouchPlayer(p.Id, p.Owner.Id, now.Add(-time.Minute)))
+
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	owner, ok := got.Player(p.Owner.Id)
+	require.True(t, ok)
+	assert.True(t, now.Equal(owner.LastSeen), "last seen must not go back")
+	assert.ErrorIs(t, repo.TouchPlayer(p.Id, uuid.NewString(), now), planning.ErrUnknownPlayer)
+	assert.ErrorIs(t, repo.TouchPlayer(uuid.NewString(), p.Owner.Id, now), planning.ErrPlanningNotFound)
+}
+
+func testChangesCountUpVersion(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	version := func() int64 {
+		t.Helper()
+		got, err := repo.GetById(p.Id)
+		require.NoError(t, err)
+		return got.Version
+	}
+	before := version()
+
+	player := JoinPlayer(t, repo, p.Id, "player")
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: player.Id, CardId: "5"}))
+	revealed, _, err := repo.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+
+	assert.Equal(t, before+3, revealed.Version, "every change counts up the version")
+	_, _, err = repo.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, repo.Touch(p.Id, time.Now()))
+	require.NoError(t, repo.TouchPlayer(p.Id, player.Id, time.Now()))
+	assert.Equal(t, revealed.Version, version(), "what clients don't see is no change")
+	require.NoError(t, repo.ResetVotes(p.Id))
+	assert.Equal(t, revealed.Version+1, version())
+}
+
+func testReturnedPlanningIsACopy(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	_, err := repo.AddStory(p.Id, planning.Story{Id: uuid.NewString(), Title: "story"})
+	require.NoError(t, err)
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "5"}))
+	revealed, _, err := repo.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+
+	revealed.Votes[p.Owner.Id] = "8"
+	revealed.HiddenVotes["intruder"] = "1"
+	revealed.Players[0].Role = planning.RoleObserver
+	revealed.Stories[0].Title = "changed"
+	revealed.Stories[0].Rounds[0].Votes[p.Owner.Id] = "8"
+	revealed.Deck.Cards[0].Id = "changed"
+
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{p.Owner.Id: "5"}, got.Votes)
+	assert.Equal(t, map[string]string{p.Owner.Id: "5"}, got.HiddenVotes)
+	assert.Equal(t, planning.RoleVoter, got.Players[0].Role)
+	assert.Equal(t, "story", got.Stories[0].Title)
+	assert.Equal(t, map[string]string{p.Owner.Id: "5"}, got.Stories[0].Rounds[0].Votes)
+	assert.Equal(t, p.Deck, got.Deck)
+}
+
+func testRevealedVotesAreNotSharedWithHiddenVotes(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "5"}))
+	revealed, _, err := repo.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+
+	revealed.Votes[p.Owner.Id] = "8"
+
+	assert.Equal(t, "5", revealed.HiddenVotes[p.Owner.Id])
+}
+
+func testConcurrentJoins(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	const players = 20
+
+	var wg sync.WaitGroup
+	for i := range players {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.Join(p.Id, planning.Player{Id: uuid.NewString(), Name: fmt.Sprintf("player %d", i), Role: planning.RoleVoter})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.Len(t, got.Players, players+1)
+}
+
+func testConcurrentVotes(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	const players = 20
+	ids := make([]string, players)
+	for i := range ids {
+		ids[i] = JoinPlayer(t, repo, p.Id, fmt.Sprintf("player %d", i)).Id
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: id, CardId: "5"}))
+			_, err := repo.GetById(p.Id)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	assert.Len(t, got.HiddenVotes, players)
+}
+
+func testConcurrentRevealRecordsOneRound(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	story := planning.Story{Id: uuid.NewString(), Title: "story"}
+	_, err := repo.AddStory(p.Id, story)
+	require.NoError(t, err)
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "5"}))
+
+	var wg sync.WaitGroup
+	var reveals atomic.Int32
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, revealed, err := repo.RevealVotes(p.Id, time.Now())
+			assert.NoError(t, err)
+			if revealed {
+				reveals.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), reveals.Load(), "exactly one reveal must report that it revealed the round")
+
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	require.Len(t, got.Stories, 1)
+	assert.Len(t, got.Stories[0].Rounds, 1, "revealing an already revealed round must not record it twice")
+}
+
+// testConcurrentReadsAndWrites reads the returned plannings while other goroutines change
+// the planning, so the race detector reports any state shared with the repository.
+func testConcurrentReadsAndWrites(t *testing.T, repo planning.Repository) {
+	p := CreateWithOwner(t, repo)
+	const players = 10
+	ids := make([]string, players)
+	for i := range ids {
+		ids[i] = JoinPlayer(t, repo, p.Id, fmt.Sprintf("player %d", i)).Id
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			// Another player may have revealed the round in between
+			if err := repo.Vote(p.Id, planning.Vote{PlayerId: id, CardId: "5"}); err != nil {
+				assert.ErrorIs(t, err, planning.ErrRoundRevealed)
+			}
+			_, _, err := repo.RevealVotes(p.Id, time.Now())
+			assert.NoError(t, err)
+			assert.NoError(t, repo.ResetVotes(p.Id))
+		}()
+		go func() {
+			defer wg.Done()
+			got, err := repo.GetById(p.Id)
+			if !assert.NoError(t, err) {
+				return
+			}
+			for playerId, vote := range got.Votes {
+				got.Votes[playerId] = vote + "!"
+			}
+			for playerId := range got.HiddenVotes {
+				delete(got.HiddenVotes, playerId)
+			}
+			for i := range got.Players {
+				got.Players[i].Name = "changed"
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := repo.GetById(p.Id)
+	require.NoError(t, err)
+	for _, player := range got.Players {
+		assert.NotEqual(t, "changed", player.Name)
+	}
+}
@@ -0,0 +1,108 @@
+package planning
+
+import (
+	"math"
+	"slices"
+)
+
+// RoundStats summarizes the revealed votes of a round. Mode and consensus take every card
+// except "?" and "☕" into account, so they work for T-shirt sizes as well. The numbers only
+// take cards with a numeric value, they are nil when nobody picked a numeric card.
+type RoundStats struct {
+	Count       int      `json:"count"` // Count is the number of numeric votes the numbers are calculated from
+	Average     *float64 `json:"average"`
+	Median      *float64 `json:"median"`
+	Mode        []string `json:"mode"` // Mode holds the most picked cards, more than one on a tie
+	Min         *float64 `json:"min"`
+	Max         *float64 `json:"max"`
+	NearestCard string   `json:"nearestCard"` // NearestCard is the numeric deck card closest to the average
+	Consensus   bool     `json:"consensus"`   // Consensus is true when everybody picked the same card and nobody abstained
+}
+
+// CalculateStats computes the statistics of the votes against the cards of the deck
+func CalculateStats(deck Deck, votes map[string]string) RoundStats {
+	var values []float64
+	counts := make(map[string]int)
+	abstained := false
+	for _, cardId := range votes {
+		card, ok := deck.Card(cardId)
+		if !ok {
+			continue
+		}
+		if card.Abstains() {
+			abstained = true
+			continue
+		}
+		counts[card.Id]++
+		if card.Value != nil {
+			values = append(values, *card.Value)
+		}
+	}
+	stats := RoundStats{Count: len(values), Mode: []string{}, Consensus: !abstained && len(counts) == 1}
+
+	// Walk the deck so the mode keeps the deck order
+	best := 0
+	for _, card := range deck.Cards {
+		if n := counts[card.Id]; n > best {
+			best = n
+			stats.Mode = []string{card.Id}
+		} else if n > 0 && n == best {
+			stats.Mode = append(stats.Mode, card.Id)
+		}
+	}
+
+	if len(values) == 0 {
+		return stats
+	}
+	slices.Sort(values)
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	average := sum / float64(len(values))
+	median := values[len(values)/2]
+	if len(values)%2 == 0 {
+		median = (values[len(values)/2-1] + values[len(values)/2]) / 2
+	}
+	stats.Average = &average
+	stats.Median = &median
+	stats.Min = &values[0]
+	stats.Max = &values[len(values)-1]
+
+	var nearest *Card
+	distance := math.Inf(1)
+	for _, card := range deck.Cards {
+		if card.Value == nil {
+			continue
+		}
+		// Prefer the higher card when the average is right between two cards, whatever their order in the deck
+		d := math.Abs(*card.Value - average)
+		if d < distance || (d == distance && *card.Value > *nearest.Value) {
+			distance = d
+			nearest = &card
+		}
+	}
+	if nearest != nil {
+		stats.NearestCard = nearest.Id
+	}
+	return stats
+}
+
+func (s RoundStats) clone() RoundStats {
+	c := s
+	c.Average = clonePtr(s.Average)
+	c.Median = clonePtr(s.Median)
+	c.Min = clonePtr(s.Min)
+	c.Max = clonePtr(s.Max)
+	c.Mode = slices.Clone(s.Mode)
+	return c
+}
+
+func clonePtr(v *float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	return &c
+}
@@ -0,0 +1,115 @@
+package planning
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestCalculateStats(t *testing.T) {
+	fibonacci, _ := NewDeck(DeckFibonacci, nil)
+	modified, _ := NewDeck(DeckModifiedFibonacci, nil)
+	tshirt, _ := NewDeck(DeckTShirt, nil)
+	descending, _ := NewDeck(DeckCustom, []Card{NewCard("5"), NewCard("3"), NewCard("2"), NewCard("1")})
+
+	tests := []struct {
+		name  string
+		deck  Deck
+		votes map[string]string
+		want  RoundStats
+	}{
+		{
+			name:  "no votes",
+			deck:  fibonacci,
+			votes: map[string]string{},
+			want:  RoundStats{Mode: []string{}},
+		},
+		{
+			name:  "single vote",
+			deck:  fibonacci,
+			votes: map[string]string{"a": "5"},
+			want:  RoundStats{Count: 1, Average: float(5), Median: float(5), Mode: []string{"5"}, Min: float(5), Max: float(5), NearestCard: "5", Consensus: true},
+		},
+		{
+			name:  "consensus",
+			deck:  fibonacci,
+			votes: map[string]string{"a": "8", "b": "8", "c": "8"},
+			want:  RoundStats{Count: 3, Average: float(8), Median: float(8), Mode: []string{"8"}, Min: float(8), Max: float(8), NearestCard: "8", Consensus: true},
+		},
+		{
+			name:  "odd number of votes",
+			deck:  fibonacci,
+			votes: map[string]string{"a": "1", "b": "3", "c": "3", "d": "13", "e": "5"},
+			want:  RoundStats{Count: 5, Average: float(5), Median: float(3), Mode: []string{"3"}, Min: float(1), Max: float(13), NearestCard: "5"},
+		},
+		{
+			name:  "even number of votes",
+			deck:  fibonacci,
+			votes: map[string]string{"a": "2", "b": "3", "c": "5", "d": "8"},
+			want:  RoundStats{Count: 4, Average: float(4.5), Median: float(4), Mode: []string{"2", "3", "5", "8"}, Min: float(2), Max: float(8), NearestCard: "5"},
+		},
+		{
+			name:  "average between two cards picks the higher one",
+			deck:  fibonacci,
+			votes: map[string]string{"a": "2", "b": "3"},
+			want:  RoundStats{Count: 2, Average: float(2.5), Median: float(2.5), Mode: []string{"2", "3"}, Min: float(2), Max: float(3), NearestCard: "3"},
+		},
+		{
+			name:  "abstaining cards are not counted and prevent consensus",
+			deck:  modified,
+			votes: map[string]string{"a": "½", "b": "?", "c": "☕", "d": "½"},
+			want:  RoundStats{Count: 2, Average: float(0.5), Median: float(0.5), Mode: []string{"½"}, Min: float(0.5), Max: float(0.5), NearestCard: "½"},
+		},
+		{
+			name:  "only abstaining cards",
+			deck:  modified,
+			votes: map[string]string{"a": "?", "b": "☕"},
+			want:  RoundStats{Mode: []string{}},
+		},
+		{
+			name:  "non-numeric cards",
+			deck:  tshirt,
+			votes: map[string]string{"a": "M", "b": "L", "c": "L"},
+			want:  RoundStats{Mode: []string{"L"}},
+		},
+		{
+			name:  "non-numeric tie",
+			deck:  tshirt,
+			votes: map[string]string{"a": "M", "b": "L"},
+			want:  RoundStats{Mode: []string{"M", "L"}},
+		},
+		{
+			name:  "non-numeric consensus",
+			deck:  tshirt,
+			votes: map[string]string{"a": "XL", "b": "XL"},
+			want:  RoundStats{Mode: []string{"XL"}, Consensus: true},
+		},
+		{
+			name:  "non-numeric consensus with abstention",
+			deck:  tshirt,
+			votes: map[string]string{"a": "XL", "b": "XL", "c": "?"},
+			want:  RoundStats{Mode: []string{"XL"}},
+		},
+		{
+			name:  "average between two cards picks the higher value in any deck order",
+			deck:  descending,
+			votes: map[string]string{"a": "2", "b": "3"},
+			want:  RoundStats{Count: 2, Average: float(2.5), Median: float(2.5), Mode: []string{"3", "2"}, Min: float(2), Max: float(3), NearestCard: "3"},
+		},
+		{
+			name:  "cards not in the deck are ignored",
+			deck:  fibonacci,
+			votes: map[string]string{"a": "4", "b": "3"},
+			want:  RoundStats{Count: 1, Average: float(3), Median: float(3), Mode: []string{"3"}, Min: float(3), Max: float(3), NearestCard: "3", Consensus: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CalculateStats(tt.deck, tt.votes))
+		})
+	}
+}
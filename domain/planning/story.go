@@ -0,0 +1,44 @@
+package planning
+
+import (
+	"maps"
+	"time"
+)
+
+// Story is a backlog item that is estimated in one or more rounds
+type Story struct {
+	Id          string  `json:"id"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	ExternalKey string  `json:"externalKey"` // ExternalKey references the item in an issue tracker, e.g. "PROJ-123"
+	Rounds      []Round `json:"rounds"`
+}
+
+// Round is the outcome of one reveal of a story
+type Round struct {
+	Votes      map[string]string `json:"votes"`    // Vote key is player ID
+	Estimate   string            `json:"estimate"` // Estimate is the card the team agreed on, empty until set
+	RevealedAt time.Time         `json:"revealedAt"`
+}
+
+// CurrentStory returns the story that is estimated right now
+func (p Planning) CurrentStory() (Story, bool) {
+	for _, story := range p.Stories {
+		if story.Id == p.CurrentStoryId {
+			return story, true
+		}
+	}
+	return Story{}, false
+}
+
+func (s Story) clone() Story {
+	c := s
+	if s.Rounds != nil {
+		c.Rounds = make([]Round, len(s.Rounds))
+		for i, round := range s.Rounds {
+			c.Rounds[i] = round
+			c.Rounds[i].Votes = maps.Clone(round.Votes)
+		}
+	}
+	return c
+}
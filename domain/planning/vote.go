@@ -0,0 +1,7 @@
+package planning
+
+// Vote is the card a player picked in the current round
+type Vote struct {
+	PlayerId string `json:"playerId"`
+	CardId   string `json:"cardId"`
+}
@@ -1,65 +1,154 @@
 package in_memory
 
 import (
-	"errors"
+	"hash/fnv"
+	"maps"
 	"planning-poker/domain/planning"
+	"slices"
 	"sync"
+	"time"
 )
 
+// shardCount is the number of independently locked maps the plannings are spread over
+const shardCount = 64
+
+// PlanningRepository keeps the plannings in memory. Plannings are deep-copied when
+// they are stored and when they are returned, so no caller shares state with the repository.
+//
+// The plannings are spread over shards that are only locked to look up, add or remove a
+// planning. Changes lock the single planning, so sessions don't wait for each other.
 type PlanningRepository struct {
-	activeSessions map[string]planning.Planning
-	sessionLock    sync.Mutex
+	shards [shardCount]shard
+}
+
+type shard struct {
+	mu             sync.RWMutex
+	activeSessions map[string]*session
+}
+
+// session holds a single planning and the lock for changing it
+type session struct {
+	mu      sync.Mutex
+	plan    planning.Planning
+	deleted bool // deleted is set once the planning is removed, for callers that still hold the session
 }
 
 func NewPlanningRepository() *PlanningRepository {
-	return &PlanningRepository{
-		activeSessions: make(map[string]planning.Planning),
+	p := &PlanningRepository{}
+	for i := range p.shards {
+		p.shards[i].activeSessions = make(map[string]*session)
+	}
+	return p
+}
+
+func (p *PlanningRepository) shard(planningId string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(planningId))
+	return &p.shards[h.Sum32()%shardCount]
+}
+
+// lock returns the locked session of a planning, the caller has to unlock it
+func (p *PlanningRepository) lock(planningId string) (*session, error) {
+	sh := p.shard(planningId)
+	sh.mu.RLock()
+	s, ok := sh.activeSessions[planningId]
+	sh.mu.RUnlock()
+	if !ok {
+		return nil, planning.ErrPlanningNotFound
+	}
+	s.mu.Lock()
+	if s.deleted {
+		s.mu.Unlock()
+		return nil, planning.ErrPlanningNotFound
+	}
+	return s, nil
+}
+
+// update changes a planning under its lock and returns a copy of the result
+func (p *PlanningRepository) update(planningId string, fn func(plan *planning.Planning) error) (planning.Planning, error) {
+	s, err := p.lock(planningId)
+	if err != nil {
+		return planning.Planning{}, err
+	}
+	defer s.mu.Unlock()
+	if err := fn(&s.plan); err != nil {
+		return planning.Planning{}, err
+	}
+	return s.plan.Clone(), nil
+}
+
+// change changes a planning like update and counts up its version
+func (p *PlanningRepository) change(planningId string, fn func(plan *planning.Planning) error) (planning.Planning, error) {
+	return p.update(planningId, func(plan *planning.Planning) error {
+		if err := fn(plan); err != nil {
+			return err
+		}
+		plan.Version++
+		return nil
+	})
+}
+
+// remove deletes a planning. Must be called with the lock of the session held.
+func (p *PlanningRepository) remove(planningId string, s *session) {
+	s.deleted = true
+	sh := p.shard(planningId)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.activeSessions[planningId] == s {
+		delete(sh.activeSessions, planningId)
 	}
 }
 
-func (p *PlanningRepository) Create(planning planning.Planning) error {
-	p.sessionLock.Lock()
-	defer p.sessionLock.Unlock()
-	if _, ok := p.activeSessions[planning.Id]; ok {
-		return errors.New("planning with this id already exists")
+func (p *PlanningRepository) Create(plan planning.Planning) error {
+	sh := p.shard(plan.Id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.activeSessions[plan.Id]; ok {
+		return planning.ErrPlanningExists
 	}
-	p.activeSessions[planning.Id] = planning
+	sh.activeSessions[plan.Id] = &session{plan: plan.Clone()}
 	return nil
 }
 
 func (p *PlanningRepository) GetById(id string) (planning.Planning, error) {
-	p.sessionLock.Lock()
-	defer p.sessionLock.Unlock()
-	plan, ok := p.activeSessions[id]
-	if !ok {
-		return planning.Planning{}, errors.New("planning with this id does not exist")
+	return p.update(id, func(plan *planning.Planning) error { return nil })
+}
+
+// GetAll returns every stored planning
+func (p *PlanningRepository) GetAll() ([]planning.Planning, error) {
+	var plans []planning.Planning
+	for i := range p.shards {
+		sh := &p.shards[i]
+		sh.mu.RLock()
+		ids := slices.Collect(maps.Keys(sh.activeSessions))
+		sh.mu.RUnlock()
+		for _, id := range ids {
+			if plan, err := p.GetById(id); err == nil {
+				plans = append(plans, plan)
+			}
+		}
 	}
-	return plan, nil
+	return plans, nil
 }
 
 func (p *PlanningRepository) Join(planningId string, player planning.Player) (planning.Planning, error) {
-	p.sessionLock.Lock()
-	defer p.sessionLock.Unlock()
-	plan, ok := p.activeSessions[planningId]
-	if !ok {
-		return planning.Planning{}, errors.New("planning with this id does not exist")
-	}
-	plan.Players = append(plan.Players, player)
-	if player.IsOwner {
-		plan.Owner = player
-	}
-	p.activeSessions[planningId] = plan
-	return plan, nil
+	return p.change(planningId, func(plan *planning.Planning) error {
+		plan.Players = append(plan.Players, player)
+		if player.IsOwner {
+			plan.Owner = player
+		}
+		return nil
+	})
 }
 
 // Leave allows a player to leave a planning session
 func (p *PlanningRepository) Leave(planningId string, playerId string) (planning.Planning, error) {
-	p.sessionLock.Lock()
-	defer p.sessionLock.Unlock()
-	plan, ok := p.activeSessions[planningId]
-	if !ok {
-		return planning.Planning{}, errors.New("planning with this id does not exist")
+	s, err := p.lock(planningId)
+	if err != nil {
+		return planning.Planning{}, err
 	}
+	defer s.mu.Unlock()
+	plan := &s.plan
 	var updatedPlayers []planning.Player
 	for _, player := range plan.Players {
 		if player.Id != playerId {
@@ -68,60 +157,192 @@ func (p *PlanningRepository) Leave(planningId string, playerId string) (planning
 	}
 	plan.Players = updatedPlayers
 	if len(plan.Players) == 0 {
-		delete(p.activeSessions, planningId)
+		p.remove(planningId, s)
 		return planning.Planning{}, nil
 	}
 	delete(plan.Votes, playerId)
 	delete(plan.HiddenVotes, playerId)
 	if plan.Owner.Id == playerId {
-		plan.Owner = plan.Players[0]
+		// Observers only take over when nobody else is left
+		next := slices.IndexFunc(plan.Players, planning.Player.CanVote)
+		plan.Owner = plan.Players[max(next, 0)]
 	}
-	p.activeSessions[planningId] = plan
-	return plan, nil
+	plan.Version++
+	return plan.Clone(), nil
 }
 
-func (p *PlanningRepository) Vote(planningId string, playerId string, value int) error {
-	p.sessionLock.Lock()
-	defer p.sessionLock.Unlock()
-	plan, ok := p.activeSessions[planningId]
-	if !ok {
-		return errors.New("planning with this id does not exist")
+func (p *PlanningRepository) SetRole(planningId string, playerId string, role planning.Role) (planning.Planning, error) {
+	return p.change(planningId, func(plan *planning.Planning) error {
+		i := slices.IndexFunc(plan.Players, func(player planning.Player) bool { return player.Id == playerId })
+		if i < 0 {
+			return planning.ErrUnknownPlayer
+		}
+		plan.Players[i].Role = role
+		if plan.Owner.Id == playerId {
+			plan.Owner.Role = role
+		}
+		if role == planning.RoleObserver {
+			delete(plan.Votes, playerId)
+			delete(plan.HiddenVotes, playerId)
+		}
+		return nil
+	})
+}
+
+func (p *PlanningRepository) Vote(planningId string, vote planning.Vote) error {
+	s, err := p.lock(planningId)
+	if err != nil {
+		return err
 	}
-	plan.HiddenVotes[playerId] = value
-	plan.Votes[playerId] = 0
-	p.activeSessions[planningId] = plan
+	defer s.mu.Unlock()
+	if s.plan.Revealed {
+		return planning.ErrRoundRevealed
+	}
+	s.plan.HiddenVotes[vote.PlayerId] = vote.CardId
+	s.plan.Votes[vote.PlayerId] = ""
+	s.plan.Version++
 	return nil
 }
 
-func (p *PlanningRepository) RevealVotes(planningId string) (planning.Planning, error) {
-	p.sessionLock.Lock()
-	defer p.sessionLock.Unlock()
-	plan, ok := p.activeSessions[planningId]
-	if !ok {
-		return planning.Planning{}, errors.New("planning with this id does not exist")
-	}
-	plan.Votes = plan.HiddenVotes
-	plan.Revealed = true
-	p.activeSessions[planningId] = plan
-	return plan, nil
+func (p *PlanningRepository) RevealVotes(planningId string, revealedAt time.Time) (planning.Planning, bool, error) {
+	revealed := false
+	plan, err := p.update(planningId, func(plan *planning.Planning) error {
+		if plan.Revealed {
+			return nil
+		}
+		revealed = true
+		plan.Version++
+		plan.Votes = maps.Clone(plan.HiddenVotes)
+		plan.Revealed = true
+		plan.Timer = nil
+		for i := range plan.Stories {
+			if plan.Stories[i].Id == plan.CurrentStoryId {
+				round := planning.Round{Votes: maps.Clone(plan.HiddenVotes), RevealedAt: revealedAt}
+				plan.Stories[i].Rounds = append(plan.Stories[i].Rounds, round)
+			}
+		}
+		return nil
+	})
+	return plan, revealed, err
 }
 
 func (p *PlanningRepository) ResetVotes(planningId string) error {
-	p.sessionLock.Lock()
-	defer p.sessionLock.Unlock()
-	plan, ok := p.activeSessions[planningId]
-	if !ok {
-		return errors.New("planning with this id does not exist")
+	_, err := p.change(planningId, func(plan *planning.Planning) error {
+		plan.Votes = make(map[string]string)
+		plan.HiddenVotes = make(map[string]string)
+		plan.Revealed = false
+		plan.Timer = nil
+		return nil
+	})
+	return err
+}
+
+func (p *PlanningRepository) AddStory(planningId string, story planning.Story) (planning.Planning, error) {
+	return p.change(planningId, func(plan *planning.Planning) error {
+		plan.Stories = append(plan.Stories, story)
+		if plan.CurrentStoryId == "" {
+			plan.CurrentStoryId = story.Id
+		}
+		// The story comes from the caller, so the stored planning gets its own copy
+		*plan = plan.Clone()
+		return nil
+	})
+}
+
+// SetCurrentStory moves the planning to another story and starts a fresh round
+func (p *PlanningRepository) SetCurrentStory(planningId string, storyId string) (planning.Planning, error) {
+	return p.change(planningId, func(plan *planning.Planning) error {
+		if !slices.ContainsFunc(plan.Stories, func(story planning.Story) bool { return story.Id == storyId }) {
+			return planning.ErrUnknownStory
+		}
+		plan.CurrentStoryId = storyId
+		plan.Votes = make(map[string]string)
+		plan.HiddenVotes = make(map[string]string)
+		plan.Revealed = false
+		plan.Timer = nil
+		return nil
+	})
+}
+
+// SetEstimate records the agreed estimate on the latest round of a story
+func (p *PlanningRepository) SetEstimate(planningId string, storyId string, estimate string) (planning.Planning, error) {
+	return p.change(planningId, func(plan *planning.Planning) error {
+		i := slices.IndexFunc(plan.Stories, func(story planning.Story) bool { return story.Id == storyId })
+		if i < 0 {
+			return planning.ErrUnknownStory
+		}
+		rounds := plan.Stories[i].Rounds
+		if len(rounds) == 0 {
+			return planning.ErrNoRound
+		}
+		rounds[len(rounds)-1].Estimate = estimate
+		return nil
+	})
+}
+
+// SetTimer starts a timer on the planning, nil stops it
+func (p *PlanningRepository) SetTimer(planningId string, timer *planning.Timer) (planning.Planning, error) {
+	return p.change(planningId, func(plan *planning.Planning) error {
+		if timer != nil {
+			t := *timer
+			timer = &t
+		}
+		plan.Timer = timer
+		return nil
+	})
+}
+
+// Touch records activity on the planning, like a player connecting or voting
+func (p *PlanningRepository) Touch(planningId string, at time.Time) error {
+	_, err := p.update(planningId, func(plan *planning.Planning) error {
+		plan.LastConnected = at
+		return nil
+	})
+	return err
+}
+
+// TouchPlayer records that a player was connected at the given time. LastSeen never goes back.
+func (p *PlanningRepository) TouchPlayer(planningId string, playerId string, at time.Time) error {
+	_, err := p.update(planningId, func(plan *planning.Planning) error {
+		i := slices.IndexFunc(plan.Players, func(player planning.Player) bool { return player.Id == playerId })
+		if i < 0 {
+			return planning.ErrUnknownPlayer
+		}
+		if at.After(plan.Players[i].LastSeen) {
+			plan.Players[i].LastSeen = at
+		}
+		return nil
+	})
+	return err
+}
+
+// DeleteIdle deletes all plannings without activity since idleSince
+func (p *PlanningRepository) DeleteIdle(idleSince time.Time) ([]string, error) {
+	var deleted []string
+	for i := range p.shards {
+		sh := &p.shards[i]
+		// Sessions are locked before their shard when removing, so the shard is not held while locking them
+		sh.mu.RLock()
+		sessions := make(map[string]*session, len(sh.activeSessions))
+		maps.Copy(sessions, sh.activeSessions)
+		sh.mu.RUnlock()
+		for planningId, s := range sessions {
+			s.mu.Lock()
+			if !s.deleted && s.plan.LastConnected.Before(idleSince) {
+				p.remove(planningId, s)
+				deleted = append(deleted, planningId)
+			}
+			s.mu.Unlock()
+		}
 	}
-	plan.Votes = make(map[string]int)
-	plan.HiddenVotes = make(map[string]int)
-	plan.Revealed = false
-	p.activeSessions[planningId] = plan
-	return nil
+	return deleted, nil
 }
 
 func (p *PlanningRepository) Close(planningId string) {
-	p.sessionLock.Lock()
-	defer p.sessionLock.Unlock()
-	delete(p.activeSessions, planningId)
+	s, err := p.lock(planningId)
+	if err != nil {
+		return
+	}
+	defer s.mu.Unlock()
+	p.remove(planningId, s)
 }
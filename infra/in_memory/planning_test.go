@@ -0,0 +1,48 @@
+package in_memory
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"planning-poker/domain/planning"
+	"planning-poker/domain/planning/repositorytest"
+)
+
+func TestPlanningRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) planning.Repository {
+		return NewPlanningRepository()
+	})
+}
+
+// BenchmarkPlanningRepository_Sessions votes and reads in parallel, spread over the given number of sessions
+func BenchmarkPlanningRepository_Sessions(b *testing.B) {
+	for _, sessions := range []int{1, 1000} {
+		b.Run(fmt.Sprintf("sessions=%d", sessions), func(b *testing.B) {
+			repo := NewPlanningRepository()
+			plans := make([]planning.Planning, sessions)
+			for i := range plans {
+				plans[i] = repositorytest.NewPlanning()
+				if err := repo.Create(plans[i]); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := repo.Join(plans[i].Id, plans[i].Owner); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					p := plans[next.Add(1)%int64(sessions)]
+					if err := repo.Vote(p.Id, planning.Vote{PlayerId: p.Owner.Id, CardId: "5"}); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := repo.GetById(p.Id); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
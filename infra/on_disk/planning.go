@@ -0,0 +1,417 @@
+package on_disk
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"planning-poker/domain/planning"
+	"planning-poker/infra"
+	"planning-poker/infra/in_memory"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	snapshotFile = "plannings.json"
+	logFile      = "plannings.log"
+	// compactAfter is the number of log entries after which the log is folded into a new snapshot
+	compactAfter = 1000
+	// lockCount is the number of locks the plannings are spread over
+	lockCount = 64
+)
+
+// PlanningRepository keeps all plannings in memory and persists every change to disk.
+// The state is stored as a JSON snapshot plus an append-only log of changed plannings
+// that is replayed on startup and compacted into the snapshot from time to time.
+//
+// Changes of a planning hold its lock until they are in the log, so the log has the same
+// order as the memory for every planning while different plannings don't wait for each other.
+// The log is synced for a group of changes at once: while one sync runs, the following
+// changes are appended and wait for the next sync, which covers all of them.
+type PlanningRepository struct {
+	mem    *in_memory.PlanningRepository
+	dir    string
+	locks  [lockCount]sync.Mutex // locks serialize the changes of the plannings hashed to them
+	logger *zap.Logger
+
+	logMu      sync.Mutex // logMu guards the fields below, it is held to append and to compact
+	log        *os.File
+	logEntries int
+	written    uint64          // written counts the entries appended to the log
+	ids        map[string]bool // ids of all stored plannings, needed for snapshots
+
+	syncMu sync.Mutex // syncMu is held while syncing or compacting the log, before logMu
+	synced uint64     // synced counts the entries that are on disk, guarded by syncMu
+}
+
+// record is the stored form of a planning. Unlike the JSON sent to clients it contains the hidden votes.
+type record struct {
+	planningRecord
+	HiddenVotes map[string]string `json:"hiddenVotes"`
+}
+
+// planningRecord drops the client facing MarshalJSON of planning.Planning
+type planningRecord planning.Planning
+
+type logEntry struct {
+	Id       string  `json:"id"`
+	Planning *record `json:"planning,omitempty"` // Planning is nil when the planning was deleted
+}
+
+// NewPlanningRepository opens the repository in dir and restores all plannings stored there
+func NewPlanningRepository(dir string) (*PlanningRepository, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	repo := &PlanningRepository{
+		mem:    in_memory.NewPlanningRepository(),
+		dir:    dir,
+		ids:    make(map[string]bool),
+		logger: infra.GetLogger(),
+	}
+	if err := repo.load(); err != nil {
+		return nil, err
+	}
+	// Start with a fresh snapshot so the log only has to hold changes of this run
+	if err := repo.compact(); err != nil {
+		return nil, err
+	}
+	repo.logger.Info("Restored plannings from disk", zap.String("dir", dir), zap.Int("count", len(repo.ids)))
+	return repo, nil
+}
+
+// lock locks the changes of a planning and returns the function to unlock them
+func (p *PlanningRepository) lock(planningId string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(planningId))
+	mu := &p.locks[h.Sum32()%lockCount]
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (p *PlanningRepository) load() error {
+	data, err := os.ReadFile(filepath.Join(p.dir, snapshotFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		var records []record
+		if err := json.Unmarshal(data, &records); err != nil {
+			return err
+		}
+		for _, r := range records {
+			p.restore(r.Id, &r)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(p.dir, logFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry logEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			if scanner.Scan() {
+				return fmt.Errorf("corrupt planning log entry in line %d: %w", line, err)
+			}
+			// A crash while appending leaves a partial last line behind, everything before it is fine
+			p.logger.Warn("Skipping truncated last planning log entry", zap.Error(err))
+			break
+		}
+		p.restore(entry.Id, entry.Planning)
+	}
+	return scanner.Err()
+}
+
+func (p *PlanningRepository) restore(id string, r *record) {
+	p.mem.Close(id)
+	delete(p.ids, id)
+	if r == nil {
+		return
+	}
+	plan := planning.Planning(r.planningRecord)
+	plan.HiddenVotes = r.HiddenVotes
+	if plan.Votes == nil {
+		plan.Votes = make(map[string]string)
+	}
+	if plan.HiddenVotes == nil {
+		plan.HiddenVotes = make(map[string]string)
+	}
+	if err := p.mem.Create(plan); err == nil {
+		p.ids[id] = true
+	}
+}
+
+// persist appends the current state of a planning to the log and waits until it is on disk.
+// Must be called with the lock of the planning held.
+func (p *PlanningRepository) persist(id string) error {
+	entry := logEntry{Id: id}
+	if plan, err := p.mem.GetById(id); err == nil {
+		entry.Planning = toRecord(plan)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	written, err := p.append(entry, data)
+	if err != nil {
+		p.logger.Error("Error writing planning log", zap.String("planningId", id), zap.Error(err))
+		return err
+	}
+	if err := p.sync(written); err != nil {
+		p.logger.Error("Error syncing planning log", zap.String("planningId", id), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// append writes an entry to the log and returns the number of entries written up to it
+func (p *PlanningRepository) append(entry logEntry, data []byte) (uint64, error) {
+	p.logMu.Lock()
+	defer p.logMu.Unlock()
+	if _, err := p.log.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	if entry.Planning != nil {
+		p.ids[entry.Id] = true
+	} else {
+		delete(p.ids, entry.Id)
+	}
+	p.logEntries++
+	p.written++
+	return p.written, nil
+}
+
+// sync returns once the first written entries of the log are on disk. A sync covers every
+// entry appended before it started, so changes that wait for a running sync share the next one.
+func (p *PlanningRepository) sync(written uint64) error {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+	if p.synced >= written {
+		return nil
+	}
+	p.logMu.Lock()
+	if p.logEntries >= compactAfter {
+		target := p.written
+		err := p.compact()
+		p.logMu.Unlock()
+		if err == nil {
+			// The snapshot is synced and holds every entry of the log
+			p.synced = target
+			return nil
+		}
+		p.logger.Error("Error compacting planning log", zap.Error(err))
+		p.logMu.Lock()
+	}
+	log, target := p.log, p.written
+	p.logMu.Unlock()
+	if err := log.Sync(); err != nil {
+		return err
+	}
+	p.synced = target
+	return nil
+}
+
+// compact writes all plannings into a new snapshot and starts an empty log. Must be called with
+// syncMu and logMu held.
+func (p *PlanningRepository) compact() error {
+	records := make([]*record, 0, len(p.ids))
+	for id := range p.ids {
+		plan, err := p.mem.GetById(id)
+		if err != nil {
+			continue
+		}
+		records = append(records, toRecord(plan))
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(p.dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(p.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if p.log != nil {
+		_ = p.log.Close()
+	}
+	p.log, err = os.OpenFile(filepath.Join(p.dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	p.logEntries = 0
+	return nil
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func toRecord(plan planning.Planning) *record {
+	return &record{planningRecord: planningRecord(plan), HiddenVotes: plan.HiddenVotes}
+}
+
+func (p *PlanningRepository) Create(plan planning.Planning) error {
+	defer p.lock(plan.Id)()
+	if err := p.mem.Create(plan); err != nil {
+		return err
+	}
+	return p.persist(plan.Id)
+}
+
+func (p *PlanningRepository) GetById(id string) (planning.Planning, error) {
+	return p.mem.GetById(id)
+}
+
+func (p *PlanningRepository) GetAll() ([]planning.Planning, error) {
+	return p.mem.GetAll()
+}
+
+func (p *PlanningRepository) Join(planningId string, player planning.Player) (planning.Planning, error) {
+	defer p.lock(planningId)()
+	plan, err := p.mem.Join(planningId, player)
+	if err != nil {
+		return plan, err
+	}
+	return plan, p.persist(planningId)
+}
+
+func (p *PlanningRepository) Leave(planningId string, playerId string) (planning.Planning, error) {
+	defer p.lock(planningId)()
+	plan, err := p.mem.Leave(planningId, playerId)
+	if err != nil {
+		return plan, err
+	}
+	return plan, p.persist(planningId)
+}
+
+func (p *PlanningRepository) SetRole(planningId string, playerId string, role planning.Role) (planning.Planning, error) {
+	defer p.lock(planningId)()
+	plan, err := p.mem.SetRole(planningId, playerId, role)
+	if err != nil {
+		return plan, err
+	}
+	return plan, p.persist(planningId)
+}
+
+func (p *PlanningRepository) Vote(planningId string, vote planning.Vote) error {
+	defer p.lock(planningId)()
+	if err := p.mem.Vote(planningId, vote); err != nil {
+		return err
+	}
+	return p.persist(planningId)
+}
+
+func (p *PlanningRepository) RevealVotes(planningId string, revealedAt time.Time) (planning.Planning, bool, error) {
+	defer p.lock(planningId)()
+	plan, revealed, err := p.mem.RevealVotes(planningId, revealedAt)
+	if err != nil || !revealed {
+		return plan, revealed, err
+	}
+	return plan, revealed, p.persist(planningId)
+}
+
+func (p *PlanningRepository) ResetVotes(planningId string) error {
+	defer p.lock(planningId)()
+	if err := p.mem.ResetVotes(planningId); err != nil {
+		return err
+	}
+	return p.persist(planningId)
+}
+
+func (p *PlanningRepository) AddStory(planningId string, story planning.Story) (planning.Planning, error) {
+	defer p.lock(planningId)()
+	plan, err := p.mem.AddStory(planningId, story)
+	if err != nil {
+		return plan, err
+	}
+	return plan, p.persist(planningId)
+}
+
+func (p *PlanningRepository) SetCurrentStory(planningId string, storyId string) (planning.Planning, error) {
+	defer p.lock(planningId)()
+	plan, err := p.mem.SetCurrentStory(planningId, storyId)
+	if err != nil {
+		return plan, err
+	}
+	return plan, p.persist(planningId)
+}
+
+func (p *PlanningRepository) SetEstimate(planningId string, storyId string, estimate string) (planning.Planning, error) {
+	defer p.lock(planningId)()
+	plan, err := p.mem.SetEstimate(planningId, storyId, estimate)
+	if err != nil {
+		return plan, err
+	}
+	return plan, p.persist(planningId)
+}
+
+func (p *PlanningRepository) SetTimer(planningId string, timer *planning.Timer) (planning.Planning, error) {
+	defer p.lock(planningId)()
+	plan, err := p.mem.SetTimer(planningId, timer)
+	if err != nil {
+		return plan, err
+	}
+	return plan, p.persist(planningId)
+}
+
+// Touch is not persisted on its own, every change and snapshot afterwards takes LastConnected along.
+// After a restart a planning may be closed a bit earlier than without it.
+func (p *PlanningRepository) Touch(planningId string, at time.Time) error {
+	return p.mem.Touch(planningId, at)
+}
+
+// TouchPlayer is not persisted, when the server restarts every player starts unseen
+func (p *PlanningRepository) TouchPlayer(planningId string, playerId string, at time.Time) error {
+	return p.mem.TouchPlayer(planningId, playerId, at)
+}
+
+func (p *PlanningRepository) DeleteIdle(idleSince time.Time) ([]string, error) {
+	deleted, err := p.mem.DeleteIdle(idleSince)
+	if err != nil {
+		return nil, err
+	}
+	for _, planningId := range deleted {
+		unlock := p.lock(planningId)
+		err := p.persist(planningId)
+		unlock()
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func (p *PlanningRepository) Close(planningId string) {
+	defer p.lock(planningId)()
+	p.mem.Close(planningId)
+	if err := p.persist(planningId); err != nil {
+		p.logger.Error("Error persisting closed planning", zap.String("planningId", planningId), zap.Error(err))
+	}
+}
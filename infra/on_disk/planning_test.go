@@ -0,0 +1,143 @@
+package on_disk
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"planning-poker/domain/planning"
+	"planning-poker/domain/planning/repositorytest"
+)
+
+func TestPlanningRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) planning.Repository {
+		repo, err := NewPlanningRepository(t.TempDir())
+		require.NoError(t, err)
+		return repo
+	})
+}
+
+func TestPlanningRepository_RestoresPlanningsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewPlanningRepository(dir)
+	require.NoError(t, err)
+
+	p := repositorytest.CreateWithOwner(t, repo)
+	voter := repositorytest.JoinPlayer(t, repo, p.Id, "voter")
+	require.NoError(t, repo.Vote(p.Id, planning.Vote{PlayerId: voter.Id, CardId: "5"}))
+	closed := repositorytest.CreateWithOwner(t, repo)
+	repo.Close(closed.Id)
+
+	restarted, err := NewPlanningRepository(dir)
+	require.NoError(t, err)
+
+	restored, err := restarted.GetById(p.Id)
+	require.NoError(t, err)
+	assert.Equal(t, p.Owner.Id, restored.Owner.Id)
+	assert.Len(t, restored.Players, 2)
+	assert.Equal(t, map[string]string{voter.Id: ""}, restored.Votes)
+	assert.Equal(t, map[string]string{voter.Id: "5"}, restored.HiddenVotes)
+	_, err = restarted.GetById(closed.Id)
+	assert.ErrorIs(t, err, planning.ErrPlanningNotFound)
+
+	// Changes after the restart are kept as well
+	revealed, _, err := restarted.RevealVotes(p.Id, time.Now())
+	require.NoError(t, err)
+	restarted, err = NewPlanningRepository(dir)
+	require.NoError(t, err)
+	restored, err = restarted.GetById(p.Id)
+	require.NoError(t, err)
+	assert.True(t, restored.Revealed)
+	assert.Equal(t, revealed.Votes, restored.Votes)
+}
+
+func TestPlanningRepository_RestoresConcurrentChanges(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewPlanningRepository(dir)
+	require.NoError(t, err)
+
+	// Enough changes to compact the log while other plannings are written
+	const plannings, players = 8, compactAfter / 8
+	var wg sync.WaitGroup
+	created := make([]planning.Planning, plannings)
+	for i := range created {
+		created[i] = repositorytest.CreateWithOwner(t, repo)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range players {
+				_, err := repo.Join(created[i].Id, planning.Player{Id: uuid.NewString(), Name: "player"})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	restarted, err := NewPlanningRepository(dir)
+	require.NoError(t, err)
+	for _, p := range created {
+		restored, err := restarted.GetById(p.Id)
+		require.NoError(t, err)
+		assert.Len(t, restored.Players, players+1)
+	}
+}
+
+func TestPlanningRepository_RejectsCorruptLogEntry(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewPlanningRepository(dir)
+	require.NoError(t, err)
+	p := repositorytest.CreateWithOwner(t, repo)
+	name := filepath.Join(dir, logFile)
+	entries, err := os.ReadFile(name)
+	require.NoError(t, err)
+	corrupt := "{\"id\":\"" + p.Id + "\",\"planning\":{\"id\"\n"
+	require.NoError(t, os.WriteFile(name, append([]byte(corrupt), entries...), 0o644))
+
+	_, err = NewPlanningRepository(dir)
+	assert.Error(t, err, "a corrupt entry followed by others must not be skipped")
+}
+
+func TestPlanningRepository_TouchIsKeptWithTheNextChange(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewPlanningRepository(dir)
+	require.NoError(t, err)
+	p := repositorytest.CreateWithOwner(t, repo)
+	touched := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+
+	before, err := os.Stat(filepath.Join(dir, logFile))
+	require.NoError(t, err)
+	require.NoError(t, repo.Touch(p.Id, touched))
+	after, err := os.Stat(filepath.Join(dir, logFile))
+	require.NoError(t, err)
+	assert.Equal(t, before.Size(), after.Size(), "touching must not write the log")
+
+	repositorytest.JoinPlayer(t, repo, p.Id, "player")
+	restarted, err := NewPlanningRepository(dir)
+	require.NoError(t, err)
+	restored, err := restarted.GetById(p.Id)
+	require.NoError(t, err)
+	assert.True(t, touched.Equal(restored.LastConnected))
+}
+
+func TestPlanningRepository_IgnoresTruncatedLogEntry(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewPlanningRepository(dir)
+	require.NoError(t, err)
+	p := repositorytest.CreateWithOwner(t, repo)
+
+	log, err := os.OpenFile(filepath.Join(dir, logFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = log.WriteString(`{"id":"` + p.Id + `","planning":{"id"`)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	restarted, err := NewPlanningRepository(dir)
+	require.NoError(t, err)
+	_, err = restarted.GetById(p.Id)
+	assert.NoError(t, err)
+}
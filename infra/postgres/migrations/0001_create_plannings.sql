@@ -0,0 +1,52 @@
+CREATE TABLE plannings (
+    id                TEXT PRIMARY KEY,
+    owner_id          TEXT NOT NULL,
+    owner_name        TEXT NOT NULL,
+    owner_role        TEXT NOT NULL,
+    deck              JSONB NOT NULL,
+    auto_reveal       BOOLEAN NOT NULL DEFAULT FALSE,
+    current_story_id  TEXT NOT NULL DEFAULT '',
+    revealed          BOOLEAN NOT NULL DEFAULT FALSE,
+    timer_deadline    TIMESTAMPTZ,
+    timer_auto_reveal BOOLEAN NOT NULL DEFAULT FALSE,
+    last_connected    TEXT NOT NULL DEFAULT '',
+    created_at        TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE players (
+    planning_id TEXT NOT NULL REFERENCES plannings (id) ON DELETE CASCADE,
+    id          TEXT NOT NULL,
+    name        TEXT NOT NULL,
+    role        TEXT NOT NULL,
+    position    BIGSERIAL,
+    PRIMARY KEY (planning_id, id)
+);
+
+-- votes holds the votes of the current round, they are only shown to the players once the planning is revealed
+CREATE TABLE votes (
+    planning_id TEXT NOT NULL REFERENCES plannings (id) ON DELETE CASCADE,
+    player_id   TEXT NOT NULL,
+    card_id     TEXT NOT NULL,
+    PRIMARY KEY (planning_id, player_id)
+);
+
+CREATE TABLE stories (
+    planning_id  TEXT NOT NULL REFERENCES plannings (id) ON DELETE CASCADE,
+    id           TEXT NOT NULL,
+    title        TEXT NOT NULL,
+    description  TEXT NOT NULL,
+    external_key TEXT NOT NULL,
+    position     BIGSERIAL,
+    PRIMARY KEY (planning_id, id)
+);
+
+CREATE TABLE rounds (
+    planning_id TEXT NOT NULL,
+    story_id    TEXT NOT NULL,
+    number      INTEGER NOT NULL,
+    votes       JSONB NOT NULL,
+    estimate    TEXT NOT NULL DEFAULT '',
+    revealed_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (planning_id, story_id, number),
+    FOREIGN KEY (planning_id, story_id) REFERENCES stories (planning_id, id) ON DELETE CASCADE
+);
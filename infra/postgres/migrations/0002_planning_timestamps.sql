@@ -0,0 +1,10 @@
+-- The timestamps used to be unused text columns, so there is nothing worth converting
+ALTER TABLE plannings
+    ALTER COLUMN last_connected DROP DEFAULT,
+    ALTER COLUMN last_connected TYPE TIMESTAMPTZ USING now(),
+    ALTER COLUMN last_connected SET DEFAULT now(),
+    ALTER COLUMN created_at DROP DEFAULT,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING now(),
+    ALTER COLUMN created_at SET DEFAULT now();
+
+CREATE INDEX plannings_last_connected ON plannings (last_connected);
@@ -0,0 +1,2 @@
+-- last_seen tells the instances whether a player is still connected to any of them
+ALTER TABLE players ADD COLUMN last_seen TIMESTAMPTZ NOT NULL DEFAULT now();
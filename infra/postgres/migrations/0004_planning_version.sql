@@ -0,0 +1,2 @@
+-- version counts the changes of a planning, so clients can tell which snapshot is newer
+ALTER TABLE plannings ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
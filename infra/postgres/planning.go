@@ -0,0 +1,541 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"planning-poker/domain/planning"
+	"planning-poker/infra"
+	"time"
+
+	_ "github.com/lib/pq" // registers the "postgres" driver
+	"go.uber.org/zap"
+)
+
+// PlanningRepository stores plannings in PostgreSQL. Every change runs in its own
+// transaction that locks the planning row, so concurrent votes and reveals of the
+// same planning are applied one after another.
+type PlanningRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// NewPlanningRepository migrates the database and returns a repository using it
+func NewPlanningRepository(db *sql.DB) (*PlanningRepository, error) {
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+	return &PlanningRepository{db: db, logger: infra.GetLogger()}, nil
+}
+
+func (p *PlanningRepository) Create(plan planning.Planning) error {
+	deck, err := json.Marshal(plan.Deck)
+	if err != nil {
+		return err
+	}
+	var deadline *time.Time
+	timerAutoReveal := false
+	if plan.Timer != nil {
+		deadline = &plan.Timer.Deadline
+		timerAutoReveal = plan.Timer.AutoReveal
+	}
+	res, err := p.db.Exec(`
+		INSERT INTO plannings (id, owner_id, owner_name, owner_role, deck, auto_reveal, current_story_id, revealed,
+			timer_deadline, timer_auto_reveal, last_connected, created_at, version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (id) DO NOTHING`,
+		plan.Id, plan.Owner.Id, plan.Owner.Name, string(plan.Owner.Role), string(deck), plan.Settings.AutoReveal,
+		plan.CurrentStoryId, plan.Revealed, deadline, timerAutoReveal, plan.LastConnected, plan.CreatedAt, plan.Version)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return planning.ErrPlanningExists
+	}
+	return nil
+}
+
+func (p *PlanningRepository) GetById(id string) (planning.Planning, error) {
+	return load(p.db, id)
+}
+
+// GetAll returns every stored planning
+func (p *PlanningRepository) GetAll() ([]planning.Planning, error) {
+	rows, err := p.db.Query(`SELECT id FROM plannings`)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	plans := make([]planning.Planning, 0, len(ids))
+	for _, id := range ids {
+		plan, err := load(p.db, id)
+		if errors.Is(err, planning.ErrPlanningNotFound) {
+			continue // deleted in the meantime
+		}
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func (p *PlanningRepository) Join(planningId string, player planning.Player) (planning.Planning, error) {
+	return p.update(planningId, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			INSERT INTO players (planning_id, id, name, role, last_seen) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (planning_id, id) DO UPDATE SET name = excluded.name, role = excluded.role`,
+			planningId, player.Id, player.Name, string(player.Role), player.LastSeen); err != nil {
+			return err
+		}
+		if player.IsOwner {
+			_, err := tx.Exec(`UPDATE plannings SET owner_id = $2, owner_name = $3, owner_role = $4 WHERE id = $1`,
+				planningId, player.Id, player.Name, string(player.Role))
+			return err
+		}
+		return nil
+	})
+}
+
+// Leave allows a player to leave a planning session
+func (p *PlanningRepository) Leave(planningId string, playerId string) (planning.Planning, error) {
+	return p.update(planningId, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM players WHERE planning_id = $1 AND id = $2`, planningId, playerId); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM votes WHERE planning_id = $1 AND player_id = $2`, planningId, playerId); err != nil {
+			return err
+		}
+		var next planning.Player
+		err := tx.QueryRow(`SELECT id, name, role FROM players WHERE planning_id = $1 ORDER BY role = $2, position LIMIT 1`,
+			planningId, string(planning.RoleObserver)).
+			Scan(&next.Id, &next.Name, &next.Role)
+		if errors.Is(err, sql.ErrNoRows) {
+			_, err = tx.Exec(`DELETE FROM plannings WHERE id = $1`, planningId)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		// The first remaining voter or facilitator takes over if the owner left, observers only
+		// when nobody else is left
+		_, err = tx.Exec(`
+			UPDATE plannings SET owner_id = $2, owner_name = $3, owner_role = $4
+			WHERE id = $1 AND owner_id = $5`,
+			planningId, next.Id, next.Name, string(next.Role), playerId)
+		return err
+	})
+}
+
+func (p *PlanningRepository) SetRole(planningId string, playerId string, role planning.Role) (planning.Planning, error) {
+	return p.update(planningId, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE players SET role = $3 WHERE planning_id = $1 AND id = $2`, planningId, playerId, string(role))
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return planning.ErrUnknownPlayer
+		}
+		if _, err := tx.Exec(`UPDATE plannings SET owner_role = $3 WHERE id = $1 AND owner_id = $2`, planningId, playerId, string(role)); err != nil {
+			return err
+		}
+		if role == planning.RoleObserver {
+			_, err = tx.Exec(`DELETE FROM votes WHERE planning_id = $1 AND player_id = $2`, planningId, playerId)
+		}
+		return err
+	})
+}
+
+func (p *PlanningRepository) Vote(planningId string, vote planning.Vote) error {
+	return p.inTx(planningId, func(tx *sql.Tx, revealed bool) error {
+		if revealed {
+			return planning.ErrRoundRevealed
+		}
+		_, err := tx.Exec(`
+			INSERT INTO votes (planning_id, player_id, card_id) VALUES ($1, $2, $3)
+			ON CONFLICT (planning_id, player_id) DO UPDATE SET card_id = excluded.card_id`,
+			planningId, vote.PlayerId, vote.CardId)
+		if err != nil {
+			return err
+		}
+		return countChange(tx, planningId)
+	})
+}
+
+func (p *PlanningRepository) RevealVotes(planningId string, revealedAt time.Time) (planning.Planning, bool, error) {
+	var plan planning.Planning
+	revealedNow := false
+	err := p.inTx(planningId, func(tx *sql.Tx, revealed bool) error {
+		if !revealed {
+			revealedNow = true
+			if _, err := tx.Exec(`UPDATE plannings SET revealed = TRUE, timer_deadline = NULL, version = version + 1 WHERE id = $1`, planningId); err != nil {
+				return err
+			}
+			// Record the round on the current story, plannings without stories have no history
+			if _, err := tx.Exec(`
+				INSERT INTO rounds (planning_id, story_id, number, votes, revealed_at)
+				SELECT s.planning_id, s.id,
+					(SELECT COUNT(*) FROM rounds r WHERE r.planning_id = s.planning_id AND r.story_id = s.id),
+					(SELECT COALESCE(jsonb_object_agg(v.player_id, v.card_id), '{}'::jsonb) FROM votes v WHERE v.planning_id = s.planning_id),
+					$2::timestamptz
+				FROM plannings pl JOIN stories s ON s.planning_id = pl.id AND s.id = pl.current_story_id
+				WHERE pl.id = $1`,
+				planningId, revealedAt); err != nil {
+				return err
+			}
+		}
+		var err error
+		plan, err = load(tx, planningId)
+		return err
+	})
+	if err != nil {
+		return planning.Planning{}, false, err
+	}
+	return plan, revealedNow, nil
+}
+
+func (p *PlanningRepository) ResetVotes(planningId string) error {
+	return p.inTx(planningId, func(tx *sql.Tx, _ bool) error {
+		if err := resetRound(tx, planningId); err != nil {
+			return err
+		}
+		return countChange(tx, planningId)
+	})
+}
+
+func (p *PlanningRepository) AddStory(planningId string, story planning.Story) (planning.Planning, error) {
+	return p.update(planningId, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			INSERT INTO stories (planning_id, id, title, description, external_key) VALUES ($1, $2, $3, $4, $5)`,
+			planningId, story.Id, story.Title, story.Description, story.ExternalKey); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE plannings SET current_story_id = $2 WHERE id = $1 AND current_story_id = ''`, planningId, story.Id)
+		return err
+	})
+}
+
+// SetCurrentStory moves the planning to another story and starts a fresh round
+func (p *PlanningRepository) SetCurrentStory(planningId string, storyId string) (planning.Planning, error) {
+	return p.update(planningId, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE plannings SET current_story_id = $2 WHERE id = $1
+			AND EXISTS (SELECT 1 FROM stories WHERE planning_id = $1 AND id = $2)`, planningId, storyId)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return planning.ErrUnknownStory
+		}
+		return resetRound(tx, planningId)
+	})
+}
+
+// SetEstimate records the agreed estimate on the latest round of a story
+func (p *PlanningRepository) SetEstimate(planningId string, storyId string, estimate string) (planning.Planning, error) {
+	return p.update(planningId, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM stories WHERE planning_id = $1 AND id = $2)`, planningId, storyId).
+			Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return planning.ErrUnknownStory
+		}
+		res, err := tx.Exec(`
+			UPDATE rounds SET estimate = $3
+			WHERE planning_id = $1 AND story_id = $2
+				AND number = (SELECT MAX(number) FROM rounds WHERE planning_id = $1 AND story_id = $2)`,
+			planningId, storyId, estimate)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return planning.ErrNoRound
+		}
+		return nil
+	})
+}
+
+// SetTimer starts a timer on the planning, nil stops it
+func (p *PlanningRepository) SetTimer(planningId string, timer *planning.Timer) (planning.Planning, error) {
+	return p.update(planningId, func(tx *sql.Tx) error {
+		var deadline *time.Time
+		autoReveal := false
+		if timer != nil {
+			deadline = &timer.Deadline
+			autoReveal = timer.AutoReveal
+		}
+		_, err := tx.Exec(`UPDATE plannings SET timer_deadline = $2, timer_auto_reveal = $3 WHERE id = $1`,
+			planningId, deadline, autoReveal)
+		return err
+	})
+}
+
+// Touch records activity on the planning, like a player connecting or voting
+func (p *PlanningRepository) Touch(planningId string, at time.Time) error {
+	res, err := p.db.Exec(`UPDATE plannings SET last_connected = $2 WHERE id = $1`, planningId, at)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return planning.ErrPlanningNotFound
+	}
+	return nil
+}
+
+// TouchPlayer records that a player was connected at the given time. LastSeen never goes back.
+func (p *PlanningRepository) TouchPlayer(planningId string, playerId string, at time.Time) error {
+	res, err := p.db.Exec(`UPDATE players SET last_seen = GREATEST(last_seen, $3) WHERE planning_id = $1 AND id = $2`,
+		planningId, playerId, at)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	var exists bool
+	if err := p.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM plannings WHERE id = $1)`, planningId).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return planning.ErrPlanningNotFound
+	}
+	return planning.ErrUnknownPlayer
+}
+
+// DeleteIdle deletes all plannings without activity since idleSince
+func (p *PlanningRepository) DeleteIdle(idleSince time.Time) ([]string, error) {
+	rows, err := p.db.Query(`DELETE FROM plannings WHERE last_connected < $1 RETURNING id`, idleSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deleted []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, id)
+	}
+	return deleted, rows.Err()
+}
+
+func (p *PlanningRepository) Close(planningId string) {
+	if _, err := p.db.Exec(`DELETE FROM plannings WHERE id = $1`, planningId); err != nil {
+		p.logger.Error("Error closing planning", zap.String("planningId", planningId), zap.Error(err))
+	}
+}
+
+// inTx runs fn in a transaction that holds the lock of the planning row
+func (p *PlanningRepository) inTx(planningId string, fn func(tx *sql.Tx, revealed bool) error) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var revealed bool
+	err = tx.QueryRow(`SELECT revealed FROM plannings WHERE id = $1 FOR UPDATE`, planningId).Scan(&revealed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return planning.ErrPlanningNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := fn(tx, revealed); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// update runs fn like inTx, counts up the version and returns the planning as it is after the change
+func (p *PlanningRepository) update(planningId string, fn func(tx *sql.Tx) error) (planning.Planning, error) {
+	var plan planning.Planning
+	err := p.inTx(planningId, func(tx *sql.Tx, _ bool) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if err := countChange(tx, planningId); err != nil {
+			return err
+		}
+		var err error
+		plan, err = load(tx, planningId)
+		if errors.Is(err, planning.ErrPlanningNotFound) {
+			// The last player left and the planning was deleted
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return planning.Planning{}, err
+	}
+	return plan, nil
+}
+
+// countChange counts up the version of a planning
+func countChange(tx *sql.Tx, planningId string) error {
+	_, err := tx.Exec(`UPDATE plannings SET version = version + 1 WHERE id = $1`, planningId)
+	return err
+}
+
+// resetRound starts a fresh round without votes and timer
+func resetRound(tx *sql.Tx, planningId string) error {
+	if _, err := tx.Exec(`DELETE FROM votes WHERE planning_id = $1`, planningId); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE plannings SET revealed = FALSE, timer_deadline = NULL WHERE id = $1`, planningId)
+	return err
+}
+
+// load reads a planning with its players, votes and stories
+func load(q querier, id string) (planning.Planning, error) {
+	plan := planning.Planning{
+		Id:          id,
+		Votes:       make(map[string]string),
+		HiddenVotes: make(map[string]string),
+	}
+	var deck []byte
+	var deadline sql.NullTime
+	var timerAutoReveal bool
+	err := q.QueryRow(`
+		SELECT owner_id, owner_name, owner_role, deck, auto_reveal, current_story_id, revealed,
+			timer_deadline, timer_auto_reveal, last_connected, created_at, version
+		FROM plannings WHERE id = $1`, id).
+		Scan(&plan.Owner.Id, &plan.Owner.Name, &plan.Owner.Role, &deck, &plan.Settings.AutoReveal, &plan.CurrentStoryId,
+			&plan.Revealed, &deadline, &timerAutoReveal, &plan.LastConnected, &plan.CreatedAt, &plan.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return planning.Planning{}, planning.ErrPlanningNotFound
+	}
+	if err != nil {
+		return planning.Planning{}, err
+	}
+	plan.Owner.IsOwner = true
+	if err := json.Unmarshal(deck, &plan.Deck); err != nil {
+		return planning.Planning{}, err
+	}
+	if deadline.Valid {
+		plan.Timer = &planning.Timer{Deadline: deadline.Time, AutoReveal: timerAutoReveal}
+	}
+	if plan.Players, err = loadPlayers(q, id, plan.Owner.Id); err != nil {
+		return planning.Planning{}, err
+	}
+	if err := loadVotes(q, &plan); err != nil {
+		return planning.Planning{}, err
+	}
+	if plan.Stories, err = loadStories(q, id); err != nil {
+		return planning.Planning{}, err
+	}
+	return plan, nil
+}
+
+func loadPlayers(q querier, planningId string, ownerId string) ([]planning.Player, error) {
+	rows, err := q.Query(`SELECT id, name, role, last_seen FROM players WHERE planning_id = $1 ORDER BY position`, planningId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var players []planning.Player
+	for rows.Next() {
+		var player planning.Player
+		if err := rows.Scan(&player.Id, &player.Name, &player.Role, &player.LastSeen); err != nil {
+			return nil, err
+		}
+		player.IsOwner = player.Id == ownerId
+		players = append(players, player)
+	}
+	return players, rows.Err()
+}
+
+// loadVotes fills the hidden votes and the votes shown to the players, which only contain the cards once revealed
+func loadVotes(q querier, plan *planning.Planning) error {
+	rows, err := q.Query(`SELECT player_id, card_id FROM votes WHERE planning_id = $1`, plan.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var playerId, cardId string
+		if err := rows.Scan(&playerId, &cardId); err != nil {
+			return err
+		}
+		plan.HiddenVotes[playerId] = cardId
+		if plan.Revealed {
+			plan.Votes[playerId] = cardId
+		} else {
+			plan.Votes[playerId] = ""
+		}
+	}
+	return rows.Err()
+}
+
+func loadStories(q querier, planningId string) ([]planning.Story, error) {
+	rows, err := q.Query(`
+		SELECT id, title, description, external_key FROM stories WHERE planning_id = $1 ORDER BY position`, planningId)
+	if err != nil {
+		return nil, err
+	}
+	var stories []planning.Story
+	index := make(map[string]int)
+	for rows.Next() {
+		var story planning.Story
+		if err := rows.Scan(&story.Id, &story.Title, &story.Description, &story.ExternalKey); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[story.Id] = len(stories)
+		stories = append(stories, story)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`
+		SELECT story_id, votes, estimate, revealed_at FROM rounds WHERE planning_id = $1 ORDER BY story_id, number`, planningId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var storyId string
+		var votes []byte
+		var round planning.Round
+		if err := rows.Scan(&storyId, &votes, &round.Estimate, &round.RevealedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(votes, &round.Votes); err != nil {
+			return nil, err
+		}
+		i := index[storyId]
+		stories[i].Rounds = append(stories[i].Rounds, round)
+	}
+	return stories, rows.Err()
+}
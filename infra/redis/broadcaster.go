@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"planning-poker/infra"
+)
+
+const (
+	// channelPrefix is followed by the planning ID in the name of the channel a planning's messages are published on
+	channelPrefix = "planning-poker:planning:"
+	// queueSize is the number of messages waiting to be published before Publish refuses more
+	queueSize = 1024
+	// publishTimeout limits how long a message may take to reach Redis
+	publishTimeout = 5 * time.Second
+)
+
+// ErrQueueFull is returned by Publish while Redis is too slow to take the messages
+var ErrQueueFull = errors.New("redis publish queue is full")
+
+// Broadcaster passes the messages for a planning between server instances over Redis pub/sub.
+// Every instance subscribes to the channels of all plannings and delivers the messages
+// to its own connections, the instance that published included.
+//
+// Publish only queues the message, so a slow or unreachable Redis doesn't hold up the change
+// that is published. The queue is sent in order by a single goroutine.
+type Broadcaster struct {
+	client      *goredis.Client
+	pubsub      *goredis.PubSub
+	logger      *zap.Logger
+	queue       chan message
+	done        chan struct{}
+	closeOnce   sync.Once
+	mu          sync.RWMutex
+	subscribers []func(planningId string, msg []byte)
+}
+
+type message struct {
+	planningId string
+	msg        []byte
+}
+
+// NewBroadcaster subscribes to the planning channels and returns once the subscription is active
+func NewBroadcaster(client *goredis.Client) (*Broadcaster, error) {
+	return newBroadcaster(client, queueSize)
+}
+
+func newBroadcaster(client *goredis.Client, queueSize int) (*Broadcaster, error) {
+	ctx := context.Background()
+	pubsub := client.PSubscribe(ctx, channelPrefix+"*")
+	// Messages published before the subscription is confirmed would be lost
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+	b := &Broadcaster{
+		client: client,
+		pubsub: pubsub,
+		logger: infra.GetLogger(),
+		queue:  make(chan message, queueSize),
+		done:   make(chan struct{}),
+	}
+	go b.receive()
+	go b.send()
+	return b, nil
+}
+
+func (b *Broadcaster) receive() {
+	for msg := range b.pubsub.Channel() {
+		planningId := strings.TrimPrefix(msg.Channel, channelPrefix)
+		b.mu.RLock()
+		subscribers := b.subscribers
+		b.mu.RUnlock()
+		for _, deliver := range subscribers {
+			deliver(planningId, []byte(msg.Payload))
+		}
+	}
+}
+
+// Publish queues a message for Redis, it never waits for Redis
+func (b *Broadcaster) Publish(planningId string, msg []byte) error {
+	select {
+	case b.queue <- message{planningId: planningId, msg: msg}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// send publishes the queued messages until the broadcaster is closed
+func (b *Broadcaster) send() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case m := <-b.queue:
+			ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+			err := b.client.Publish(ctx, channelPrefix+m.planningId, m.msg).Err()
+			cancel()
+			if err != nil {
+				b.logger.Error("Error publishing message to redis", zap.String("planningId", m.planningId), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (b *Broadcaster) Subscribe(deliver func(planningId string, msg []byte)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, deliver)
+}
+
+// Close ends the subscription and drops the messages still queued, the client stays open
+func (b *Broadcaster) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return b.pubsub.Close()
+}
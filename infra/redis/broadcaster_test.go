@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type published struct {
+	planningId string
+	msg        string
+}
+
+// newTestBroadcaster connects a broadcaster to the given Redis, like a server instance would
+func newTestBroadcaster(t *testing.T, server *miniredis.Miniredis) (*Broadcaster, chan published) {
+	t.Helper()
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	b, err := NewBroadcaster(client)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	received := make(chan published, 10)
+	b.Subscribe(func(planningId string, msg []byte) {
+		received <- published{planningId: planningId, msg: string(msg)}
+	})
+	return b, received
+}
+
+func receive(t *testing.T, received chan published) published {
+	t.Helper()
+	select {
+	case p := <-received:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+		return published{}
+	}
+}
+
+func TestBroadcaster_DeliversToEveryInstance(t *testing.T) {
+	server := miniredis.RunT(t)
+	first, receivedByFirst := newTestBroadcaster(t, server)
+	_, receivedBySecond := newTestBroadcaster(t, server)
+
+	require.NoError(t, first.Publish("planning1", []byte(`{"type":"vote"}`)))
+
+	want := published{planningId: "planning1", msg: `{"type":"vote"}`}
+	assert.Equal(t, want, receive(t, receivedByFirst))
+	assert.Equal(t, want, receive(t, receivedBySecond))
+}
+
+func TestBroadcaster_KeepsOrderOfMessages(t *testing.T) {
+	server := miniredis.RunT(t)
+	first, _ := newTestBroadcaster(t, server)
+	_, received := newTestBroadcaster(t, server)
+
+	require.NoError(t, first.Publish("planning1", []byte("vote")))
+	require.NoError(t, first.Publish("planning2", []byte("join")))
+	require.NoError(t, first.Publish("planning1", []byte("reveal")))
+
+	assert.Equal(t, published{planningId: "planning1", msg: "vote"}, receive(t, received))
+	assert.Equal(t, published{planningId: "planning2", msg: "join"}, receive(t, received))
+	assert.Equal(t, published{planningId: "planning1", msg: "reveal"}, receive(t, received))
+}
+
+func TestBroadcaster_PublishDoesNotWaitForRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	b, err := newBroadcaster(client, 1)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	server.Close()
+
+	start := time.Now()
+	err = nil
+	for i := 0; i < 10 && err == nil; i++ {
+		err = b.Publish("planning1", []byte("vote"))
+	}
+
+	assert.ErrorIs(t, err, ErrQueueFull, "messages must queue up while redis is unreachable")
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
@@ -1,19 +1,68 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
 	"net/http"
 	"os"
 	"planning-poker/application/planningsvc"
+	httpapi "planning-poker/delivery/http"
 	"planning-poker/delivery/websocket"
+	"planning-poker/domain/planning"
 	"planning-poker/infra/in_memory"
+	"planning-poker/infra/on_disk"
+	"planning-poker/infra/postgres"
+	"planning-poker/infra/redis"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
 )
 
 func main() {
-	planningRepo := in_memory.NewPlanningRepository()
-	planningSvc := planningsvc.NewPlanningService(planningRepo)
-	wsHandler := websocket.NewWebsocketHandler(planningSvc)
+	planningRepo, err := newPlanningRepository()
+	if err != nil {
+		panic(err)
+	}
+	var opts []planningsvc.Option
+	if secret := os.Getenv("RECONNECT_SECRET"); secret != "" {
+		opts = append(opts, planningsvc.WithTokenSecret([]byte(secret)))
+	} else if storage := os.Getenv("STORAGE"); storage == "file" || storage == "postgres" {
+		// A random secret changes with every restart, so nobody could resume the restored plannings
+		panic(fmt.Errorf("STORAGE=%s needs RECONNECT_SECRET to be set", storage))
+	}
+	planningSvc := planningsvc.NewPlanningService(planningRepo, opts...)
+	if err := planningSvc.Restore(); err != nil {
+		panic(err)
+	}
+	var broadcaster websocket.Broadcaster = websocket.NewLocalBroadcaster()
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		redisBroadcaster, err := newRedisBroadcaster(redisURL)
+		if err != nil {
+			panic(err)
+		}
+		defer redisBroadcaster.Close()
+		broadcaster = redisBroadcaster
+	}
+	// The websocket connections and the event streams of the REST API share one hub
+	hub := websocket.NewHub(planningSvc, broadcaster)
+	wsHandler := websocket.NewWebsocketHandler(planningSvc, websocket.WithHub(hub))
+	stopStats := hub.StartStats(10 * time.Second)
+	defer stopStats()
+
+	sessionTTL := 24 * time.Hour
+	if ttl := os.Getenv("SESSION_TTL"); ttl != "" {
+		if sessionTTL, err = time.ParseDuration(ttl); err != nil {
+			panic(err)
+		}
+	}
+	stopReaper := planningSvc.StartReaper(sessionTTL, time.Minute)
+	defer stopReaper()
+	stopHeartbeat := planningSvc.StartHeartbeat()
+	defer stopHeartbeat()
 
 	http.Handle("/ws", wsHandler)
+	http.Handle("/api/", httpapi.NewAPIHandler(planningSvc, hub))
+	http.Handle("/api/schema", websocket.SchemaHandler())
 	http.Handle("/", http.FileServer(http.Dir("./frontend/")))
 	http.HandleFunc("/session/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./frontend/index.html")
@@ -27,3 +76,34 @@ func main() {
 		panic(err)
 	}
 }
+
+// newPlanningRepository picks the storage backend from the STORAGE env var: "memory" (default), "file" or "postgres"
+func newPlanningRepository() (planning.Repository, error) {
+	switch storage := os.Getenv("STORAGE"); storage {
+	case "", "memory":
+		return in_memory.NewPlanningRepository(), nil
+	case "file":
+		dir := os.Getenv("DATA_DIR")
+		if dir == "" {
+			dir = "./data"
+		}
+		return on_disk.NewPlanningRepository(dir)
+	case "postgres":
+		db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+		if err != nil {
+			return nil, err
+		}
+		return postgres.NewPlanningRepository(db)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE %q", storage)
+	}
+}
+
+// newRedisBroadcaster shares broadcasts with the other instances connected to the same Redis
+func newRedisBroadcaster(redisURL string) (*redis.Broadcaster, error) {
+	opts, err := goredis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewBroadcaster(goredis.NewClient(opts))
+}